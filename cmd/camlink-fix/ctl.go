@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/control"
+)
+
+const ctlUsage = `usage: camlink-fix ctl [--config PATH] [--socket PATH] [--timeout DURATION] [--device NAME] check|status|heal|reset|rearm
+
+  check   check camera health, resetting it if wedged
+  status  show what the daemon is doing
+  heal    power back on ports an interrupted reset left off, then check it
+  reset   power-cycle the camera even if it looks healthy
+  rearm   let automatic resets resume after the circuit breaker tripped
+
+Without --device, commands apply to every camera the daemon supervises.
+Exits 0 if the camera is healthy afterwards (all of them, without --device),
+1 if it isn't, 2 if the daemon couldn't be reached.
+`
+
+// runCtl implements `camlink-fix ctl`, returning the process exit code.
+func runCtl(args []string) int {
+	flags := newDaemonFlags("ctl", flag.ContinueOnError)
+	fs := flags.fs
+	fs.Usage = func() { fmt.Fprint(os.Stderr, ctlUsage) }
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait for the daemon to finish")
+	device := fs.String("device", "", "Name of the camera to act on (default: all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	socket := flags.socket("ctl")
+	resp, err := control.Call(socket, control.Request{Command: fs.Arg(0), Device: *device}, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not reach camlink-fix daemon at %s: %v\n", socket, err)
+		return 2
+	}
+	return printResponse(resp)
+}
+
+func printResponse(resp control.Response) int {
+	if resp.Error != "" {
+		fmt.Fprintln(os.Stderr, resp.Error)
+		return 1
+	}
+	if resp.Status != nil || len(resp.Devices) > 0 {
+		printStatuses(os.Stdout, statuses(resp), time.Now())
+		return 0
+	}
+	fmt.Println(resp.Message)
+	if resp.Health != nil && resp.Health.ErrTail != "" && !resp.OK {
+		fmt.Println(resp.Health.ErrTail)
+	}
+	if !resp.OK {
+		return 1
+	}
+	return 0
+}
+
+// runStatus implements `camlink-fix status`, returning the process exit code.
+// Exit status follows the cameras rather than the command, so shell prompts
+// can test it directly: 0 all healthy, 1 anything else, 2 no daemon.
+//
+// With one camera, --json prints its status object; with several, an array
+// of them.
+func runStatus(args []string) int {
+	flags := newDaemonFlags("status", flag.ContinueOnError)
+	fs := flags.fs
+	asJSON := fs.Bool("json", false, "Print status as JSON")
+	device := fs.String("device", "", "Name of the camera to report on (default: all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	socket := flags.socket("status")
+	resp, err := control.Call(socket, control.Request{Command: control.CmdStatus, Device: *device}, 10*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not reach camlink-fix daemon at %s: %v\n", socket, err)
+		return 2
+	}
+	sts := statuses(resp)
+	if resp.Error != "" || len(sts) == 0 {
+		fmt.Fprintln(os.Stderr, resp.Error)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if len(sts) == 1 {
+			enc.Encode(sts[0])
+		} else {
+			enc.Encode(sts)
+		}
+	} else {
+		printStatuses(os.Stdout, sts, time.Now())
+	}
+
+	for _, st := range sts {
+		if !st.Present || st.LastCheck == nil || !st.LastCheck.Healthy() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// statuses returns the camera statuses in resp, however many there are.
+func statuses(resp control.Response) []control.Status {
+	if resp.Status != nil {
+		return []control.Status{*resp.Status}
+	}
+	return resp.Devices
+}
+
+// printStatuses writes each of sts with printStatus, a blank line apart.
+func printStatuses(w io.Writer, sts []control.Status, now time.Time) {
+	for i, st := range sts {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printStatus(w, st, now)
+	}
+}
+
+// printStatus writes st for humans, with times relative to now.
+func printStatus(w io.Writer, st control.Status, now time.Time) {
+	present := "present"
+	if !st.Present {
+		present = "not present"
+	}
+	fmt.Fprintf(w, "device:     %s (%s)\n", st.Device, present)
+
+	if st.LastCheck != nil {
+		fmt.Fprintf(w, "last check: %s\n", st.LastCheck)
+		fmt.Fprintf(w, "            %s ago, trigger %s\n", now.Sub(st.LastCheckAt).Round(time.Second), st.LastTrigger)
+	} else {
+		fmt.Fprintln(w, "last check: none yet")
+	}
+
+	switch {
+	case st.RetryAttempt > 0:
+		fmt.Fprintf(w, "resetting:  yes, retry %d of %d\n", st.RetryAttempt, st.MaxRetries)
+	case st.Resetting:
+		fmt.Fprintln(w, "resetting:  yes")
+	default:
+		fmt.Fprintln(w, "resetting:  no")
+	}
+	if len(st.Queued) > 0 {
+		fmt.Fprintf(w, "queued:     %s\n", strings.Join(st.Queued, "+"))
+	}
+
+	if r := st.LastReset; r != nil {
+		outcome := "no stage helped"
+		if r.FixedBy != "" {
+			outcome = "fixed by " + r.FixedBy
+		}
+		fmt.Fprintf(w, "last reset: %s, %s ago\n", outcome, now.Sub(st.LastResetAt).Round(time.Second))
+	}
+	if len(st.FixedBy) > 0 {
+		stages := slices.Sorted(maps.Keys(st.FixedBy))
+		fixes := make([]string, len(stages))
+		for i, s := range stages {
+			fixes[i] = fmt.Sprintf("%s ×%d", s, st.FixedBy[s])
+		}
+		fmt.Fprintf(w, "fixes:      %s\n", strings.Join(fixes, ", "))
+	}
+	if b := st.Breaker; b != nil {
+		switch {
+		case b.Tripped:
+			fmt.Fprintf(w, "breaker:    tripped %s ago after %d resets within %s, re-arms in %s\n",
+				now.Sub(b.TrippedAt).Round(time.Second), b.Resets, b.Window, b.RearmAt.Sub(now).Round(time.Second))
+		case b.Resets > 0:
+			fmt.Fprintf(w, "breaker:    %d of %d resets within %s\n", b.Resets, b.Threshold, b.Window)
+		}
+	}
+
+	if st.Location != nil {
+		loc := fmt.Sprintf("hub %s port %s", st.Location.Hub, st.Location.Port)
+		if c := st.Location; c.Companion != "" {
+			if c.CompanionPort != "" && c.CompanionPort != c.Port {
+				loc += fmt.Sprintf(" (companion hub %s port %s)", c.Companion, c.CompanionPort)
+			} else {
+				loc += fmt.Sprintf(" (companion hub %s)", c.Companion)
+			}
+		}
+		fmt.Fprintf(w, "location:   %s\n", loc)
+	}
+}
+
+// kickDaemon asks the running daemon to check the camera now. The control
+// socket is the primary path; if nothing is listening there (an older
+// daemon, or a non-default --socket) we fall back to SIGUSR1, which is
+// fire-and-forget.
+func kickDaemon(socket string) int {
+	resp, err := control.Call(socket, control.Request{Command: control.CmdCheck}, 5*time.Minute)
+	if err == nil {
+		return printResponse(resp)
+	}
+	fmt.Fprintf(os.Stderr, "control socket unavailable (%v), falling back to SIGUSR1\n", err)
+	return signalDaemon()
+}
+
+func signalDaemon() int {
+	// Find other camlink-fix processes (exclude our own PID)
+	out, err := exec.Command("pgrep", "-x", "camlink-fix").Output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "no running camlink-fix daemon found")
+		return 2
+	}
+
+	myPID := os.Getpid()
+	var targets []int
+	for _, line := range strings.Split(strings.TrimSpace(string(bytes.TrimSpace(out))), "\n") {
+		pid, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || pid == myPID {
+			continue
+		}
+		targets = append(targets, pid)
+	}
+
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "no running camlink-fix daemon found")
+		return 2
+	}
+
+	for _, pid := range targets {
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not find process %d: %v\n", pid, err)
+			continue
+		}
+		if err := proc.Signal(syscall.SIGUSR1); err != nil {
+			fmt.Fprintf(os.Stderr, "could not signal process %d: %v\n", pid, err)
+			continue
+		}
+		fmt.Printf("sent SIGUSR1 to camlink-fix (pid %d)\n", pid)
+	}
+	return 0
+}
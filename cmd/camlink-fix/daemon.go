@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/breaker"
+	"github.com/phinze/camlink-fix/internal/config"
+	"github.com/phinze/camlink-fix/internal/control"
+	"github.com/phinze/camlink-fix/internal/device"
+	"github.com/phinze/camlink-fix/internal/health"
+	"github.com/phinze/camlink-fix/internal/history"
+	"github.com/phinze/camlink-fix/internal/notify"
+	"github.com/phinze/camlink-fix/internal/reset"
+	"github.com/phinze/camlink-fix/internal/trigger"
+)
+
+// daemon holds the settings and state shared by every camera it supervises.
+type daemon struct {
+	// hubs keeps two cameras on the same hub from being power-cycled at
+	// once.
+	hubs reset.HubLocks
+
+	// cameras is fixed at startup, in config order; adding or removing a
+	// device takes a restart.
+	cameras []*camera
+
+	// mu guards cfg, which SIGHUP can swap at any time. Each cycle takes a
+	// snapshot of cfg when it starts so a reload never changes settings
+	// halfway through a reset.
+	mu  sync.Mutex
+	cfg config.Config
+}
+
+// camera holds the state of one supervised device: its own check/reset
+// cycle, trigger queue and status bookkeeping.
+type camera struct {
+	d    *daemon
+	name string
+
+	// cycle allows only one check/reset cycle at a time. Triggers queue up
+	// for it in queue; control commands refuse instead of waiting, since
+	// someone is on the other end. resetting mirrors it for status.
+	cycle     sync.Mutex
+	resetting atomic.Bool
+	queue     *trigger.Queue
+	// topology is the uhubctl scan the running cycle shares between its
+	// lookups. Every cycle starts by invalidating it, so the hub tree a
+	// reset is judged against is never older than the cycle; the camera
+	// arriving or leaving invalidates it mid-cycle too.
+	topology reset.TopologyCache
+	// breaker counts the resets triggers set off, and stops them once the
+	// camera is resetting too often for resets to be helping.
+	breaker breaker.Breaker
+
+	// mu guards the status bookkeeping.
+	mu           sync.Mutex
+	lastCheck    *health.Result
+	lastCheckAt  time.Time
+	lastTrigger  string
+	retryAttempt int
+	lastReset    *reset.Report
+	lastResetAt  time.Time
+	// fixedBy counts, per stage name, the resets that stage ended.
+	fixedBy map[string]int
+}
+
+func newDaemon(cfg config.Config) *daemon {
+	d := &daemon{cfg: cfg}
+	for _, dev := range cfg.ManagedDevices() {
+		c := &camera{d: d, name: dev.Name}
+		c.queue = trigger.New(nil, c.handleBatch)
+		d.cameras = append(d.cameras, c)
+	}
+	return d
+}
+
+func (d *daemon) config() config.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg
+}
+
+func (d *daemon) setConfig(c config.Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cfg = c
+}
+
+// camera returns the supervised camera called name.
+func (d *daemon) camera(name string) (*camera, bool) {
+	for _, c := range d.cameras {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// cameraNames lists the supervised cameras, for error messages.
+func (d *daemon) cameraNames() string {
+	names := make([]string, len(d.cameras))
+	for i, c := range d.cameras {
+		names[i] = fmt.Sprintf("%q", c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// submit queues a check of every camera.
+func (d *daemon) submit(eventName string, delay time.Duration) {
+	for _, c := range d.cameras {
+		c.submit(eventName, delay)
+	}
+}
+
+// run processes each camera's trigger queue until ctx is cancelled.
+func (d *daemon) run(ctx context.Context) {
+	for _, c := range d.cameras {
+		go c.queue.Run(ctx)
+	}
+}
+
+// settings returns a snapshot of the daemon config and this camera's entry
+// in it.
+func (c *camera) settings() (config.Config, config.Device) {
+	cfg := c.d.config()
+	dev, _ := cfg.FindDevice(c.name)
+	return cfg, dev
+}
+
+// logf logs with the camera's name in front when the daemon supervises more
+// than one, so interleaved cycles can be told apart.
+func (c *camera) logf(format string, args ...any) {
+	if len(c.d.cameras) > 1 {
+		format = c.name + ": " + format
+	}
+	log.Printf(format, args...)
+}
+
+// check runs a health check on behalf of trigger and records the result for
+// status queries.
+func (c *camera) check(ctx context.Context, cfg config.Config, dev config.Device, trigger string) health.Result {
+	res := health.Check(ctx, cfg.HealthConfig(dev))
+	c.record(trigger, res)
+	return res
+}
+
+func (c *camera) record(trigger string, res health.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCheck = &res
+	c.lastCheckAt = time.Now()
+	c.lastTrigger = trigger
+}
+
+func (c *camera) recordReset(rep reset.Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastReset = &rep
+	c.lastResetAt = time.Now()
+	if rep.FixedBy != "" {
+		if c.fixedBy == nil {
+			c.fixedBy = map[string]int{}
+		}
+		c.fixedBy[rep.FixedBy]++
+	}
+}
+
+func (c *camera) setRetryAttempt(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retryAttempt = n
+}
+
+// status snapshots what the daemon knows about the camera right now.
+func (c *camera) status() control.Status {
+	cfg, dev := c.settings()
+	st := control.Status{
+		Device:     c.name,
+		Present:    health.Listed(cfg.HealthConfig(dev)),
+		Resetting:  c.resetting.Load(),
+		MaxRetries: cfg.Retry.Max,
+	}
+	if loc, ok := reset.LastLocation(cfg.ResetEnv(), c.name); ok {
+		st.Location = &loc
+	}
+	if b, ok := c.queue.Pending(); ok {
+		st.Queued = b.Names
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastCheck != nil {
+		last := *c.lastCheck
+		st.LastCheck = &last
+	}
+	st.LastCheckAt = c.lastCheckAt
+	st.LastTrigger = c.lastTrigger
+	st.RetryAttempt = c.retryAttempt
+	if c.lastReset != nil {
+		last := *c.lastReset
+		st.LastReset = &last
+		st.LastResetAt = c.lastResetAt
+	}
+	st.FixedBy = maps.Clone(c.fixedBy)
+	if bc := cfg.BreakerConfig(); bc.Threshold > 0 {
+		bs := c.breaker.State(bc, time.Now())
+		st.Breaker = &bs
+	}
+	return st
+}
+
+func (c *camera) notify(cfg config.Config, dev config.Device, message string) {
+	if !cfg.NotifyFor(dev) {
+		return
+	}
+	if len(c.d.cameras) > 1 {
+		message = c.name + ": " + message
+	}
+	notify.Send(message)
+}
+
+// tryFix runs a health check and, if the device is in a state a reset
+// might fix, resets it. Returns the final health result: the initial
+// check if no reset was attempted, otherwise the check after the last
+// reset stage. retry is the retry attempt, for the history. auto is set
+// for cycles a trigger started, which answer to the circuit breaker; a ctl
+// command is someone asking, and resets regardless.
+func (c *camera) tryFix(ctx context.Context, cfg config.Config, dev config.Device, eventName string, retry int, auto bool) health.Result {
+	start := time.Now()
+	check := c.check(ctx, cfg, dev, eventName)
+	res, rep := check, (*reset.Report)(nil)
+	var heldOff bool
+	switch {
+	case check.Healthy():
+	case !check.NeedsReset():
+		c.logf("%s: camera %s, not resetting", eventName, check)
+	case auto && !c.mayReset(cfg):
+		c.logf("%s: camera %s, but the circuit breaker has tripped, not resetting", eventName, check)
+		heldOff = true
+	default:
+		c.logf("%s: camera %s, attempting reset...", eventName, check)
+		res, rep = c.reset(ctx, cfg, dev, eventName, check)
+		if auto && rep != nil {
+			c.countReset(cfg, dev)
+		}
+	}
+	c.addHistory(ctx, cfg, history.Record{Time: start, Trigger: eventName, Retry: retry, Check: check, Reset: rep, HeldOff: heldOff, Result: res})
+	return res
+}
+
+// mayReset asks the circuit breaker whether an automatic reset may run now,
+// logging if it has just re-armed after its cooldown.
+func (c *camera) mayReset(cfg config.Config) bool {
+	ok, rearmed := c.breaker.Allow(cfg.BreakerConfig(), time.Now())
+	if rearmed {
+		c.logf("circuit breaker re-armed after its %s cooldown", cfg.Breaker.Cooldown)
+	}
+	return ok
+}
+
+// countReset tells the circuit breaker an automatic reset ran. If that was
+// the one that tripped it, the user hears why resets are stopping; nothing
+// more is said until it re-arms.
+func (c *camera) countReset(cfg config.Config, dev config.Device) {
+	bc := cfg.BreakerConfig()
+	if !c.breaker.Record(bc, time.Now()) {
+		return
+	}
+	c.logf("circuit breaker tripped: %d resets within %s, no automatic resets for %s (or until ctl rearm)", bc.Threshold, bc.Window, bc.Cooldown)
+	c.notify(cfg, dev, fmt.Sprintf("Camera reset %d times within %s and keeps wedging — pausing automatic resets for %s (camlink-fix ctl rearm resumes them)", bc.Threshold, bc.Window, bc.Cooldown))
+}
+
+// addHistory completes r and appends it to the history file, if there is
+// one.
+func (c *camera) addHistory(ctx context.Context, cfg config.Config, r history.Record) {
+	if cfg.History == "" {
+		return
+	}
+	r.Device = c.name
+	r.Outcome = history.Classify(r.Check, r.Reset, r.Result, r.HeldOff, ctx.Err() != nil)
+	r.Duration = time.Since(r.Time)
+	if err := history.Append(cfg.History, r); err != nil {
+		log.Printf("ERROR: history: %v", err)
+	}
+}
+
+// reset locates the camera in the hub tree and runs the reset ladder on it.
+// before is the health result that prompted the reset; it's returned as-is,
+// with no report, if the camera can't be located.
+func (c *camera) reset(ctx context.Context, cfg config.Config, dev config.Device, eventName string, before health.Result) (health.Result, *reset.Report) {
+	sel, _ := dev.Selector()
+	if !sel.OnUSB() {
+		c.logf("%s isn't on USB, so there's no port to power-cycle", dev.Name)
+		c.notify(cfg, dev, fmt.Sprintf("Camera %s — it isn't on USB, so it can't be reset automatically", before))
+		return before, nil
+	}
+	topo, loc, err := c.locate(cfg, sel)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return before, nil
+	}
+	target := reset.Target{Name: c.name, Location: loc, Topology: topo}
+	if companion, ok := reset.FindCompanion(cfg.ResetEnv(), loc); ok {
+		target.Companion = companion
+	}
+
+	// Another camera on the same hub, or the hub above, may be mid-reset;
+	// wait our turn rather than cutting power under it.
+	unlock := c.d.hubs.Lock(target.Hubs()...)
+	defer unlock()
+	if ctx.Err() != nil {
+		return before, nil
+	}
+
+	// Device is present and broken — now we notify.
+	log.Printf("found %s at hub %s port %s", dev.Name, loc.Hub, loc.Port)
+	if before.Signature != health.SigNone {
+		c.notify(cfg, dev, fmt.Sprintf("Camera not responding (%s), resetting...", before.Signature))
+	} else {
+		c.notify(cfg, dev, "Resetting camera...")
+	}
+
+	rep := reset.Run(ctx, cfg.ResetEnv(), target, cfg.ResetStages(dev), cfg.HealthConfig(dev))
+	c.recordReset(rep)
+	res := rep.Result
+	if ctx.Err() != nil {
+		return res, &rep
+	}
+	// Stages refused for what else they'd power off are worth telling
+	// the user about: they're why the reset stopped short.
+	var refused string
+	if unsafe := rep.Unsafe(); len(unsafe) > 0 {
+		refused = "; not run as unsafe: " + strings.Join(unsafe, "; ")
+	}
+	if res.State == health.StateUnknown {
+		c.logf("no reset stage applied to %s", dev.Name)
+		c.notify(cfg, dev, "Camera not responding and no reset stage could run"+refused+" — try unplugging "+dev.Name)
+		return before, nil
+	}
+	c.record(eventName, res)
+	if res.Healthy() {
+		c.notify(cfg, dev, fmt.Sprintf("Camera recovered successfully (%s)", rep.FixedBy))
+		return res, &rep
+	}
+
+	c.notify(cfg, dev, fmt.Sprintf("Camera reset failed (%s)%s — try unplugging %s", res.Signature, refused, dev.Name))
+	return res, &rep
+}
+
+// locate finds the camera in uhubctl's hub tree, or failing that in sysfs:
+// uhubctl only shows hubs that can switch port power, and a camera behind
+// one that can't may still be reset by a sysfs stage. With port control
+// set to sysfs, uhubctl isn't consulted at all. The topology is returned
+// either way, for finding the companion port.
+func (c *camera) locate(cfg config.Config, sel device.Selector) (reset.Topology, reset.Location, error) {
+	if cfg.ResetEnv().PortControl == reset.PortControlSysfs {
+		loc, err := reset.LocateSysfs("", sel)
+		return reset.Topology{}, loc, err
+	}
+	topo, topoErr := c.topology.Get(cfg.UhubctlPath)
+	if topoErr == nil {
+		loc, err := reset.Locate(topo, sel)
+		if err == nil {
+			return topo, loc, nil
+		}
+		topoErr = err
+	}
+	loc, err := reset.LocateSysfs("", sel)
+	if err != nil {
+		return topo, reset.Location{}, fmt.Errorf("%v; %v", topoErr, err)
+	}
+	c.logf("%v; found it in sysfs instead", topoErr)
+	return topo, loc, nil
+}
+
+// submit queues a check for eventName once delay has passed. If a check is
+// already waiting, the event joins it rather than being dropped.
+func (c *camera) submit(eventName string, delay time.Duration) {
+	if b, ok := c.queue.Pending(); ok {
+		c.logf("%s event — joining queued check for %s", eventName, b)
+	} else if c.resetting.Load() {
+		c.logf("%s event — reset in progress, queueing a follow-up check", eventName)
+	} else if delay > 0 {
+		c.logf("%s event — waiting %s before check", eventName, delay)
+	}
+	c.queue.Submit(eventName, delay)
+}
+
+// handleBatch runs one check/reset cycle, with retries, for a batch of
+// coalesced triggers.
+func (c *camera) handleBatch(ctx context.Context, b trigger.Batch) {
+	c.cycle.Lock()
+	defer c.cycle.Unlock()
+	c.resetting.Store(true)
+	defer c.resetting.Store(false)
+	c.topology.Invalidate()
+
+	cfg, dev := c.settings()
+	eventName := b.String()
+	c.logf("%s event — checking camera health", eventName)
+
+	res := c.tryFix(ctx, cfg, dev, eventName, 0, true)
+	if ctx.Err() != nil {
+		return
+	}
+	if res.Healthy() {
+		c.logf("camera is %s", res)
+		return
+	}
+	if res.State == health.StateBusy {
+		c.logf("camera is in use by another app, leaving it alone")
+		return
+	}
+	if res.Signature != health.SigNone && !res.Signature.Resettable() {
+		c.logf("%s is not something a reset fixes, skipping retries", res.Signature)
+		c.notify(cfg, dev, fmt.Sprintf("Camera unavailable (%s) — not resetting", res.Signature))
+		return
+	}
+	if !c.mayReset(cfg) {
+		c.logf("circuit breaker has tripped, skipping retries")
+		return
+	}
+
+	// Camera didn't recover — enter retry loop, but only if the device
+	// is actually on the bus. No point retrying if it's not plugged in.
+	if !health.Listed(cfg.HealthConfig(dev)) {
+		c.logf("device not present, skipping retries")
+		return
+	}
+	if sel, _ := dev.Selector(); !sel.OnUSB() {
+		c.logf("device can't be reset, skipping retries")
+		return
+	}
+
+	c.logf("entering retry loop (every %s, up to %d attempts)", cfg.Retry.Delay, cfg.Retry.Max)
+	defer c.setRetryAttempt(0)
+	for attempt := 1; attempt <= cfg.Retry.Max; attempt++ {
+		c.setRetryAttempt(attempt)
+		select {
+		case <-time.After(cfg.Retry.Delay):
+		case <-ctx.Done():
+			return
+		}
+		if !health.Listed(cfg.HealthConfig(dev)) {
+			c.logf("retry %d/%d: device disappeared, stopping retries", attempt, cfg.Retry.Max)
+			return
+		}
+		c.logf("retry %d/%d: checking camera health...", attempt, cfg.Retry.Max)
+		res = c.tryFix(ctx, cfg, dev, fmt.Sprintf("%s/retry-%d", eventName, attempt), attempt, true)
+		if ctx.Err() != nil {
+			return
+		}
+		if res.Healthy() {
+			c.logf("camera recovered on retry %d: %s", attempt, res)
+			return
+		}
+		if res.Signature != health.SigNone && !res.Signature.Resettable() {
+			c.logf("retry %d/%d: %s is not something a reset fixes, stopping retries", attempt, cfg.Retry.Max, res.Signature)
+			return
+		}
+		if !c.mayReset(cfg) {
+			c.logf("retry %d/%d: circuit breaker has tripped, stopping retries", attempt, cfg.Retry.Max)
+			return
+		}
+	}
+	c.logf("giving up after %d retries, last result: %s", cfg.Retry.Max, res)
+	c.notify(cfg, dev, fmt.Sprintf("Camera still not working after retries (%s) — try unplugging %s", res.Signature, dev.Name))
+}
+
+// handleControl executes a control-socket command and reports the outcome.
+// A request naming a device goes to that camera alone; one that doesn't goes
+// to every camera, in config order.
+func (d *daemon) handleControl(ctx context.Context, req control.Request) control.Response {
+	switch req.Command {
+	case control.CmdStatus, control.CmdCheck, control.CmdHeal, control.CmdReset, control.CmdRearm:
+	default:
+		return control.Response{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+
+	cameras := d.cameras
+	if req.Device != "" {
+		c, ok := d.camera(req.Device)
+		if !ok {
+			return control.Response{Error: fmt.Sprintf("unknown device %q (have %s)", req.Device, d.cameraNames())}
+		}
+		cameras = []*camera{c}
+	}
+	if len(cameras) == 1 {
+		return cameras[0].handleControl(ctx, req)
+	}
+
+	if req.Command == control.CmdStatus {
+		resp := control.Response{OK: true}
+		for _, c := range cameras {
+			resp.Devices = append(resp.Devices, c.status())
+		}
+		return resp
+	}
+
+	resp := control.Response{OK: true}
+	var lines []string
+	for _, c := range cameras {
+		r := c.handleControl(ctx, req)
+		if r.Error != "" {
+			if ctx.Err() != nil {
+				return r
+			}
+			lines = append(lines, c.name+": "+r.Error)
+			resp.OK = false
+			continue
+		}
+		lines = append(lines, c.name+": "+r.Message)
+		resp.OK = resp.OK && r.OK
+	}
+	resp.Message = strings.Join(lines, "\n")
+	return resp
+}
+
+// handleControl executes a control-socket command against one camera.
+// Unlike handleBatch it doesn't enter the retry loop: someone is waiting on
+// the other end, so they get the result of one check/reset cycle and can
+// decide for themselves whether to ask again.
+func (c *camera) handleControl(ctx context.Context, req control.Request) control.Response {
+	if req.Command == control.CmdStatus {
+		st := c.status()
+		return control.Response{OK: true, Status: &st}
+	}
+	// Re-arming only touches the breaker, so it needn't wait for a cycle
+	// to finish; the next trigger sees it.
+	if req.Command == control.CmdRearm {
+		if !c.breaker.Rearm() {
+			return control.Response{OK: true, Message: "circuit breaker wasn't tripped"}
+		}
+		c.logf("manual (ctl rearm) event — circuit breaker re-armed")
+		return control.Response{OK: true, Message: "circuit breaker re-armed, automatic resets resume"}
+	}
+
+	if !c.cycle.TryLock() {
+		return control.Response{Error: "reset already in progress, try again shortly"}
+	}
+	defer c.cycle.Unlock()
+	c.resetting.Store(true)
+	defer c.resetting.Store(false)
+	c.topology.Invalidate()
+
+	cfg, dev := c.settings()
+	eventName := "manual (ctl " + req.Command + ")"
+	var res health.Result
+	var healed string
+	switch req.Command {
+	case control.CmdCheck:
+		c.logf("%s event — checking camera health", eventName)
+		res = c.tryFix(ctx, cfg, dev, eventName, 0, false)
+	case control.CmdHeal:
+		c.logf("%s event — healing ports", eventName)
+		healed = "no interrupted reset to heal; "
+		if rep, ok := reset.HealDevice(cfg.ResetEnv(), c.name); ok {
+			healed = "healed: " + rep.String() + "; "
+		}
+		start := time.Now()
+		res = c.check(ctx, cfg, dev, eventName)
+		c.addHistory(ctx, cfg, history.Record{Time: start, Trigger: eventName, Check: res, Result: res})
+	case control.CmdReset:
+		c.logf("%s event — resetting camera", eventName)
+		start := time.Now()
+		before := c.check(ctx, cfg, dev, eventName)
+		var rep *reset.Report
+		res, rep = c.reset(ctx, cfg, dev, eventName, before)
+		c.addHistory(ctx, cfg, history.Record{Time: start, Trigger: eventName, Check: before, Reset: rep, Result: res})
+	}
+
+	if ctx.Err() != nil {
+		return control.Response{Error: "daemon shutting down"}
+	}
+	c.logf("%s: camera is %s", eventName, res)
+	return control.Response{OK: res.Healthy(), Message: healed + "camera is " + res.String(), Health: &res}
+}
+
+// waitIdle waits up to timeout for every running check/reset cycle to
+// finish, reporting whether they did. Once the daemon's context is cancelled
+// a cycle winds down quickly — except that a reset caught in its off window
+// first powers the ports back on, which is what shutdown waits for.
+func (d *daemon) waitIdle(timeout time.Duration) bool {
+	idle := make(chan struct{})
+	go func() {
+		for _, c := range d.cameras {
+			c.cycle.Lock()
+		}
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
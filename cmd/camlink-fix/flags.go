@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/phinze/camlink-fix/internal/config"
+	"github.com/phinze/camlink-fix/internal/profile"
+)
+
+// daemonFlags are the daemon's command-line flags. Each one is bound to a
+// field of a scratch config.Config, so that after parsing, the flags the user
+// actually gave can be layered over whatever the config file says.
+type daemonFlags struct {
+	fs         *flag.FlagSet
+	configPath string
+	kick       bool
+	values     config.Config
+}
+
+// flagField copies one config-backed flag's value from src to dst. device
+// is set for the flags that go to [device], which [[devices]] leaves no
+// single place for.
+type flagField struct {
+	apply  func(dst, src *config.Config)
+	device bool
+}
+
+// flagFields maps each config-backed flag to its field.
+var flagFields = map[string]flagField{
+	"socket":            {func(d, s *config.Config) { d.Socket = s.Socket }, false},
+	"history":           {func(d, s *config.Config) { d.History = s.History }, false},
+	"uhubctl-path":      {func(d, s *config.Config) { d.UhubctlPath = s.UhubctlPath }, false},
+	"port-control":      {func(d, s *config.Config) { d.PortControl = s.PortControl }, false},
+	"ffmpeg-path":       {func(d, s *config.Config) { d.FFmpegPath = s.FFmpegPath }, false},
+	"device-name":       {func(d, s *config.Config) { d.Device.Name = s.Device.Name }, true},
+	"device-id":         {func(d, s *config.Config) { d.Device.ID = s.Device.ID }, true},
+	"device-serial":     {func(d, s *config.Config) { d.Device.Serial = s.Device.Serial }, true},
+	"device-match":      {func(d, s *config.Config) { d.Device.Match = s.Device.Match }, true},
+	"capture-backend":   {func(d, s *config.Config) { d.Device.CaptureBackend = s.Device.CaptureBackend }, true},
+	"no-signal-mode":    {func(d, s *config.Config) { d.Device.NoSignalMode = s.Device.NoSignalMode }, true},
+	"health-timeout":    {func(d, s *config.Config) { d.Health.Timeout = s.Health.Timeout }, false},
+	"startup-delay":     {func(d, s *config.Config) { d.Delays.Startup = s.Delays.Startup }, false},
+	"wake-delay":        {func(d, s *config.Config) { d.Delays.Wake = s.Delays.Wake }, false},
+	"usb-delay":         {func(d, s *config.Config) { d.Delays.USBArrival = s.Delays.USBArrival }, false},
+	"notify":            {func(d, s *config.Config) { d.Notify = s.Notify }, false},
+	"retry-delay":       {func(d, s *config.Config) { d.Retry.Delay = s.Retry.Delay }, false},
+	"max-retries":       {func(d, s *config.Config) { d.Retry.Max = s.Retry.Max }, false},
+	"breaker-threshold": {func(d, s *config.Config) { d.Breaker.Threshold = s.Breaker.Threshold }, false},
+	"breaker-window":    {func(d, s *config.Config) { d.Breaker.Window = s.Breaker.Window }, false},
+	"breaker-cooldown":  {func(d, s *config.Config) { d.Breaker.Cooldown = s.Breaker.Cooldown }, false},
+}
+
+func newDaemonFlags(name string, errorHandling flag.ErrorHandling) *daemonFlags {
+	f := &daemonFlags{fs: flag.NewFlagSet(name, errorHandling), values: config.Default()}
+	fs, v := f.fs, &f.values
+
+	fs.StringVar(&f.configPath, "config", config.DefaultPath(), "Path to the TOML config file")
+	fs.BoolVar(&f.kick, "kick", false, "Ask a running camlink-fix daemon to check the camera now")
+
+	fs.StringVar(&v.Socket, "socket", v.Socket, "Path to the control socket")
+	fs.StringVar(&v.History, "history", v.History, "File to record every check/reset cycle in (empty: none)")
+	fs.StringVar(&v.UhubctlPath, "uhubctl-path", v.UhubctlPath, "Path to uhubctl binary")
+	fs.StringVar(&v.PortControl, "port-control", v.PortControl, "How to switch hub port power: auto, uhubctl or sysfs")
+	fs.StringVar(&v.FFmpegPath, "ffmpeg-path", v.FFmpegPath, "Path to ffmpeg binary")
+	fs.StringVar(&v.Device.Profile, "profile", v.Device.Profile, "Device profile the camera settings default from: "+strings.Join(profile.Names(nil), ", ")+", or one from the config's [profiles]")
+	fs.StringVar(&v.Device.Name, "device-name", v.Device.Name, "Camera name for logs and notifications")
+	fs.StringVar(&v.Device.ID, "device-id", v.Device.ID, "USB vendor:product ID of the camera")
+	fs.StringVar(&v.Device.Serial, "device-serial", v.Device.Serial, "USB serial number, to pick one of several identical cameras")
+	fs.StringVar(&v.Device.Match, "device-match", v.Device.Match, "Regexp the camera's USB manufacturer and product strings must match")
+	fs.StringVar(&v.Device.CaptureBackend, "capture-backend", v.Device.CaptureBackend, "How to find and open the camera: avfoundation or v4l2")
+	fs.StringVar(&v.Device.NoSignalMode, "no-signal-mode", v.Device.NoSignalMode, "Mode the camera advertises with no HDMI input (WxH or WxH@rate)")
+	fs.DurationVar(&v.Health.Timeout, "health-timeout", v.Health.Timeout, "How long a health check waits for a frame")
+	fs.DurationVar(&v.Delays.Startup, "startup-delay", v.Delays.Startup, "Delay after daemon start before the first check")
+	fs.DurationVar(&v.Delays.Wake, "wake-delay", v.Delays.Wake, "Delay after wake before checking camera")
+	fs.DurationVar(&v.Delays.USBArrival, "usb-delay", v.Delays.USBArrival, "Delay after the camera appears on USB before checking it")
+	fs.BoolVar(&v.Notify, "notify", v.Notify, "Send desktop notifications")
+	fs.DurationVar(&v.Retry.Delay, "retry-delay", v.Retry.Delay, "Delay between retries after failed health check")
+	fs.IntVar(&v.Retry.Max, "max-retries", v.Retry.Max, "Maximum number of retries after a failed health check")
+	fs.IntVar(&v.Breaker.Threshold, "breaker-threshold", v.Breaker.Threshold, "Resets within the breaker window that stop automatic resets (0: never)")
+	fs.DurationVar(&v.Breaker.Window, "breaker-window", v.Breaker.Window, "How far back the circuit breaker counts resets")
+	fs.DurationVar(&v.Breaker.Cooldown, "breaker-cooldown", v.Breaker.Cooldown, "How long a tripped circuit breaker holds off automatic resets")
+	return f
+}
+
+// load reads the config file and overlays the flags that were set on the
+// command line. It's called once at startup and again on every SIGHUP, so a
+// reload picks up file edits without losing command-line overrides.
+func (f *daemonFlags) load() (config.Config, error) {
+	explicit := map[string]bool{}
+	f.fs.Visit(func(fl *flag.Flag) { explicit[fl.Name] = true })
+
+	// The profile is the baseline the file's device settings go over, so it
+	// has to be known before the file is read rather than applied after.
+	var profileName string
+	if explicit["profile"] {
+		profileName = f.values.Device.Profile
+	}
+	c, err := config.LoadProfile(f.configPath, explicit["config"], profileName)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if explicit["profile"] && len(c.Devices) > 0 {
+		return config.Config{}, fmt.Errorf("--profile can't be combined with [[devices]] in %s; set profile on each device instead", f.configPath)
+	}
+	for name := range explicit {
+		if field, ok := flagFields[name]; ok {
+			if len(c.Devices) > 0 && field.device {
+				return config.Config{}, fmt.Errorf("--%s can't be combined with [[devices]] in %s", name, f.configPath)
+			}
+			field.apply(&c, &f.values)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return config.Config{}, fmt.Errorf("after applying flags: %w", err)
+	}
+	return c, nil
+}
+
+// socket returns the control socket path the config file and flags give,
+// for commands that talk to the daemon. As with --kick, a config file that
+// doesn't load doesn't stop them: it's reported, and the socket falls back
+// to the flag or the default.
+func (f *daemonFlags) socket(cmd string) string {
+	c, err := f.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: config: %v\n", cmd, err)
+		return f.values.Socket
+	}
+	return c.Socket
+}
+
+const configUsage = `usage: camlink-fix config print [daemon flags]
+
+Prints the effective configuration — built-in defaults, overlaid with the
+config file, overlaid with any flags given — as TOML.
+`
+
+// runConfig implements `camlink-fix config`, returning the process exit code.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	flags := newDaemonFlags("config print", flag.ContinueOnError)
+	if err := flags.fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	c, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+	out, err := c.Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+	fmt.Printf("# config file: %s\n", flags.configPath)
+	os.Stdout.Write(out)
+	return 0
+}
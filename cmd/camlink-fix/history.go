@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/history"
+)
+
+const historyUsage = `usage: camlink-fix history [--since DURATION] [--device NAME] [--limit N] [--json] [daemon flags]
+
+Lists past incidents (cycles that found a camera wedged) from the history
+file, newest last, then sums them up: incidents per day, the triggers that
+caught them, how the camera failed, how often each reset stage fixed it,
+and the mean time between wedges. The history file is found from the same
+config and flags as the daemon's.
+`
+
+// runHistory implements `camlink-fix history`, returning the process exit
+// code.
+func runHistory(args []string) int {
+	flags := newDaemonFlags("history", flag.ContinueOnError)
+	fs := flags.fs
+	fs.Usage = func() { fmt.Fprint(os.Stderr, historyUsage) }
+	since := fs.Duration("since", 0, "Only look at cycles in the last DURATION (default: all)")
+	device := fs.String("device", "", "Only look at this camera (default: all)")
+	limit := fs.Int("limit", 20, "List at most the last N incidents (0: none, just the summary)")
+	asJSON := fs.Bool("json", false, "Print the incidents and summary as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	if c.History == "" {
+		fmt.Fprintln(os.Stderr, "history: turned off (history is empty in the config)")
+		return 1
+	}
+	all, skipped, err := history.Load(c.History)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "history: skipped %d unreadable line(s) in %s\n", skipped, c.History)
+	}
+
+	var records []history.Record
+	for _, r := range all {
+		if *device != "" && r.Device != *device {
+			continue
+		}
+		if *since > 0 && time.Since(r.Time) > *since {
+			continue
+		}
+		records = append(records, r)
+	}
+	incidents := incidentsOf(records)
+	if *limit >= 0 && len(incidents) > *limit {
+		incidents = incidents[len(incidents)-*limit:]
+	}
+	sum := history.Summarize(records)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Incidents []incident      `json:"incidents"`
+			Summary   history.Summary `json:"summary"`
+		}{incidents, sum})
+		return 0
+	}
+	// --limit 0 asks for the summary alone; saying "no incidents" above
+	// it would contradict it.
+	if *limit != 0 {
+		printIncidents(os.Stdout, incidents)
+	}
+	printSummary(os.Stdout, sum)
+	return 0
+}
+
+// incident is a cycle that found a camera wedged, and the retries it led
+// to.
+type incident struct {
+	history.Record
+	Retries []history.Record `json:"retries,omitempty"`
+}
+
+// final is the last cycle of the incident.
+func (in incident) final() history.Record {
+	if len(in.Retries) > 0 {
+		return in.Retries[len(in.Retries)-1]
+	}
+	return in.Record
+}
+
+// incidentsOf groups records, oldest first, into incidents.
+func incidentsOf(records []history.Record) []incident {
+	var out []incident
+	open := map[string]int{} // device → index in out of its latest incident
+	for _, r := range records {
+		switch {
+		case r.Incident():
+			open[r.Device] = len(out)
+			out = append(out, incident{Record: r})
+		case r.Retry > 0:
+			if i, ok := open[r.Device]; ok {
+				out[i].Retries = append(out[i].Retries, r)
+			}
+		default:
+			delete(open, r.Device)
+		}
+	}
+	return out
+}
+
+func printIncidents(w io.Writer, incidents []incident) {
+	if len(incidents) == 0 {
+		fmt.Fprintln(w, "no incidents")
+		return
+	}
+	for _, in := range incidents {
+		var stages []string
+		for _, r := range append([]history.Record{in.Record}, in.Retries...) {
+			if r.Reset == nil {
+				continue
+			}
+			for _, s := range r.Reset.Stages {
+				mark := "✗"
+				switch {
+				case s.Skipped != "":
+					mark = "skipped"
+				case r.Reset.FixedBy == s.Stage:
+					mark = "✓"
+				}
+				stages = append(stages, s.Stage+" "+mark)
+			}
+		}
+		last := in.final()
+		outcome := string(last.Outcome)
+		if len(in.Retries) > 0 {
+			outcome += fmt.Sprintf(" after %d retries", len(in.Retries))
+		}
+		fmt.Fprintf(w, "%s  %s  %s  %s  [%s]  %s\n",
+			in.Time.Local().Format("2006-01-02 15:04"), in.Device, in.Trigger, in.Failure(),
+			strings.Join(stages, ", "), outcome)
+	}
+	fmt.Fprintln(w)
+}
+
+func printSummary(w io.Writer, s history.Summary) {
+	fmt.Fprintf(w, "incidents:  %d in %d cycles\n", s.Incidents, s.Cycles)
+	if s.Incidents == 0 {
+		return
+	}
+	fmt.Fprintln(w, "per day:")
+	for _, d := range s.PerDay {
+		fmt.Fprintf(w, "  %s  %d\n", d.Key, d.Count)
+	}
+	fmt.Fprintf(w, "triggers:   %s\n", joinCounts(s.Triggers))
+	fmt.Fprintf(w, "signatures: %s\n", joinCounts(s.Signatures))
+	if len(s.Stages) > 0 {
+		fmt.Fprintln(w, "stages:")
+		for _, st := range s.Stages {
+			fmt.Fprintf(w, "  %-20s fixed %d of %d (%.0f%%)\n", st.Stage, st.Fixed, st.Ran, 100*st.SuccessRate())
+		}
+	}
+	if s.MeanBetween > 0 {
+		fmt.Fprintf(w, "mean time between wedges: %s\n", s.MeanBetween.Round(time.Minute))
+	}
+}
+
+func joinCounts(cs []history.Count) string {
+	parts := make([]string, len(cs))
+	for i, c := range cs {
+		parts[i] = fmt.Sprintf("%s ×%d", c.Key, c.Count)
+	}
+	return strings.Join(parts, ", ")
+}
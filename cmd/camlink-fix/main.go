@@ -1,93 +1,65 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strconv"
 	"strings"
-	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/phinze/camlink-fix/internal/camwatch"
-	"github.com/phinze/camlink-fix/internal/health"
-	"github.com/phinze/camlink-fix/internal/notify"
+	"github.com/phinze/camlink-fix/internal/config"
+	"github.com/phinze/camlink-fix/internal/control"
 	"github.com/phinze/camlink-fix/internal/reset"
 	"github.com/phinze/camlink-fix/internal/sleepwatch"
 	"github.com/phinze/camlink-fix/internal/usbwatch"
 )
 
-// Elgato Cam Link 4K USB IDs
-const (
-	camLinkVendorID  = 0x0fd9
-	camLinkProductID = 0x007b
-)
-
-func kickDaemon() {
-	// Find other camlink-fix processes (exclude our own PID)
-	out, err := exec.Command("pgrep", "-x", "camlink-fix").Output()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "no running camlink-fix daemon found")
-		os.Exit(1)
-	}
-
-	myPID := os.Getpid()
-	var targets []int
-	for _, line := range strings.Split(strings.TrimSpace(string(bytes.TrimSpace(out))), "\n") {
-		pid, err := strconv.Atoi(strings.TrimSpace(line))
-		if err != nil || pid == myPID {
-			continue
-		}
-		targets = append(targets, pid)
-	}
-
-	if len(targets) == 0 {
-		fmt.Fprintln(os.Stderr, "no running camlink-fix daemon found")
-		os.Exit(1)
-	}
+// shutdownTimeout bounds how long SIGTERM waits for an in-flight reset to
+// power the camera's ports back on. launchd and systemd both allow longer
+// before they SIGKILL.
+const shutdownTimeout = 8 * time.Second
 
-	for _, pid := range targets {
-		proc, err := os.FindProcess(pid)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "could not find process %d: %v\n", pid, err)
-			continue
-		}
-		if err := proc.Signal(syscall.SIGUSR1); err != nil {
-			fmt.Fprintf(os.Stderr, "could not signal process %d: %v\n", pid, err)
-			continue
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "status":
+			os.Exit(runStatus(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
 		}
-		fmt.Printf("sent SIGUSR1 to camlink-fix (pid %d)\n", pid)
 	}
-}
 
-func main() {
-	var (
-		kick         = flag.Bool("kick", false, "Send SIGUSR1 to a running camlink-fix daemon to trigger an immediate check")
-		uhubctlPath  = flag.String("uhubctl-path", "uhubctl", "Path to uhubctl binary")
-		ffmpegPath   = flag.String("ffmpeg-path", "ffmpeg", "Path to ffmpeg binary")
-		deviceName   = flag.String("device-name", "Cam Link 4K", "Camera device name as shown in system_profiler")
-		wakeDelay    = flag.Duration("wake-delay", 5*time.Second, "Delay after wake before checking camera")
-		enableNotify = flag.Bool("notify", true, "Send macOS notifications")
-		retryDelay   = flag.Duration("retry-delay", 30*time.Second, "Delay between retries after failed health check")
-		maxRetries   = flag.Int("max-retries", 10, "Maximum number of retries after a failed health check")
-	)
-	flag.Parse()
+	flags := newDaemonFlags(os.Args[0], flag.ExitOnError)
+	flags.fs.Parse(os.Args[1:])
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmsgprefix)
 	log.SetPrefix("[camlink-fix] ")
 
-	if *kick {
-		kickDaemon()
-		return
+	cfg, err := flags.load()
+	if err != nil {
+		if flags.kick {
+			// --kick only needs the socket path; don't let a broken config
+			// file stop us from poking the daemon.
+			cfg = flags.values
+		} else {
+			log.Fatalf("ERROR: config: %v", err)
+		}
 	}
 
-	log.Printf("starting (device=%q, wake-delay=%s, retry=%s×%d)", *deviceName, *wakeDelay, *retryDelay, *maxRetries)
+	if flags.kick {
+		os.Exit(kickDaemon(cfg.Socket))
+	}
+
+	log.Printf("starting (devices=%s, wake-delay=%s, retry=%s×%d)", deviceNames(cfg), cfg.Delays.Wake, cfg.Retry.Delay, cfg.Retry.Max)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -99,9 +71,13 @@ func main() {
 	usr1Ch := make(chan os.Signal, 1)
 	signal.Notify(usr1Ch, syscall.SIGUSR1)
 
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
 	// Start watchers
 	wakeCh := sleepwatch.Watch(ctx)
-	usbCh := usbwatch.Watch(ctx, camLinkVendorID, camLinkProductID)
+	d := newDaemon(cfg)
+	usbCh := watchUSB(ctx, d)
 
 	// camwatch is observe-only: it logs when any app opens a camera. We tried
 	// promoting its device-control signal to a real trigger (probe the camera
@@ -111,113 +87,39 @@ func main() {
 	// docs/edge-trigger-investigation.md.
 	camCh := camwatch.Watch(ctx)
 
-	healthCfg := health.Config{
-		FFmpegPath: *ffmpegPath,
-		DeviceName: *deviceName,
-		Timeout:    3 * time.Second,
-	}
-
-	// Debounce: only one check/reset cycle at a time
-	var resetting atomic.Bool
-
-	// tryFix attempts a health check and reset. Returns true if camera is healthy
-	// (either already healthy or recovered after reset).
-	tryFix := func(eventName string, uhubctlPath string, enableNotify bool) bool {
-		if health.Check(healthCfg) {
-			return true
-		}
-
-		log.Printf("%s: camera not responding, attempting reset...", eventName)
-
-		loc, err := reset.FindCamLink(uhubctlPath)
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-			return false
-		}
-
-		// Device is present and broken — now we notify.
-		log.Printf("found Cam Link at hub %s port %s", loc.Hub, loc.Port)
-		if enableNotify {
-			notify.Send("Camera not responding, resetting...")
-		}
-
-		companion := reset.FindCompanionHub(uhubctlPath, loc)
-
-		if reset.Run(uhubctlPath, loc, companion, healthCfg) {
-			if enableNotify {
-				notify.Send("Camera recovered successfully")
-			}
-			return true
-		}
-
-		if enableNotify {
-			notify.Send("Camera reset failed — try unplugging Cam Link")
-		}
-		return false
-	}
+	// If a previous reset was killed mid-cycle it may have left a camera's
+	// USB ports powered off; the reset journal says which. Power them back
+	// on before anything else so we never start up staring at a camera we
+	// ourselves stranded dark.
+	reset.Heal(cfg.ResetEnv())
 
-	handleEvent := func(eventName string, delay time.Duration) {
-		if !resetting.CompareAndSwap(false, true) {
-			log.Printf("reset already in progress, dropping %s event", eventName)
-			return
-		}
-		defer resetting.Store(false)
-
-		if delay > 0 {
-			log.Printf("%s event — waiting %s before check", eventName, delay)
-			time.Sleep(delay)
-		} else {
-			log.Printf("%s event — checking camera health", eventName)
-		}
-
-		if tryFix(eventName, *uhubctlPath, *enableNotify) {
-			log.Printf("camera is healthy")
-			return
-		}
-
-		// Camera didn't recover — enter retry loop, but only if the device
-		// is actually on the bus. No point retrying if it's not plugged in.
-		if !health.Listed(healthCfg) {
-			log.Printf("device not present, skipping retries")
-			return
-		}
-
-		log.Printf("entering retry loop (every %s, up to %d attempts)", *retryDelay, *maxRetries)
-		for attempt := 1; attempt <= *maxRetries; attempt++ {
-			time.Sleep(*retryDelay)
-			if !health.Listed(healthCfg) {
-				log.Printf("retry %d/%d: device disappeared, stopping retries", attempt, *maxRetries)
-				return
-			}
-			log.Printf("retry %d/%d: checking camera health...", attempt, *maxRetries)
-			if tryFix(fmt.Sprintf("%s/retry-%d", eventName, attempt), *uhubctlPath, *enableNotify) {
-				log.Printf("camera recovered on retry %d", attempt)
-				return
-			}
-		}
-		log.Printf("giving up after %d retries", *maxRetries)
-		if *enableNotify {
-			notify.Send("Camera still not working after retries — try unplugging Cam Link")
-		}
+	ln, err := control.Listen(cfg.Socket)
+	if err != nil {
+		log.Fatalf("ERROR: control socket: %v", err)
 	}
+	defer os.Remove(cfg.Socket)
+	go control.Serve(ctx, ln, d.handleControl)
 
-	// If a previous reset was killed mid-cycle it may have left the Cam Link's
-	// USB ports powered off. Power them back on before anything else so we
-	// never start up staring at a camera we ourselves stranded dark.
-	reset.Heal(*uhubctlPath)
-
-	log.Printf("ready, waiting for events...")
+	log.Printf("ready, waiting for events (control socket %s)...", cfg.Socket)
 
 	// Run one health check at startup so we catch a camera that's already
 	// on the bus but broken (e.g. daemon restarted, or machine booted docked).
-	go handleEvent("startup", 2*time.Second)
+	d.run(ctx)
+	d.submit("startup", cfg.Delays.Startup)
 
 	for {
 		select {
 		case <-wakeCh:
-			go handleEvent("wake", *wakeDelay)
-		case <-usbCh:
-			go handleEvent("usb-arrival", 2*time.Second)
+			d.submit("wake", d.config().Delays.Wake)
+		case ev := <-usbCh:
+			// The camera coming or going renumbers its part of the hub
+			// tree; a cycle running now mustn't keep using its scan. Other
+			// devices' events don't reach us, which is why every cycle
+			// starts with a fresh scan anyway.
+			ev.camera.topology.Invalidate()
+			if ev.Type == usbwatch.Arrival {
+				ev.camera.submit("usb-arrival", d.config().Delays.USBArrival)
+			}
 		case ev := <-camCh:
 			// Observe-only: log that an app reached for the camera, but do NOT
 			// probe. Attaching our own ffmpeg client to a camera an app is
@@ -228,11 +130,82 @@ func main() {
 			// manual --kick. See docs/edge-trigger-investigation.md.
 			log.Printf("camera activity observed (app=%q signal=%s) — observe-only, not probing", ev.Process, ev.Signal)
 		case <-usr1Ch:
-			go handleEvent("manual (SIGUSR1)", 0)
+			d.submit("manual (SIGUSR1)", 0)
+		case <-hupCh:
+			reloadConfig(d, flags)
 		case sig := <-sigCh:
 			log.Printf("received %s, shutting down", sig)
 			cancel()
+			if !d.waitIdle(shutdownTimeout) {
+				log.Printf("ERROR: reset still running after %s, exiting anyway; ports will be healed on next start", shutdownTimeout)
+			}
 			return
 		}
 	}
 }
+
+// cameraEvent is a USB event for one supervised camera.
+type cameraEvent struct {
+	usbwatch.Event
+	camera *camera
+}
+
+// watchUSB starts a USB watcher per camera and merges their events.
+func watchUSB(ctx context.Context, d *daemon) <-chan cameraEvent {
+	out := make(chan cameraEvent, 4)
+	cfg := d.config()
+	for _, c := range d.cameras {
+		dev, _ := cfg.FindDevice(c.name)
+		sel, _ := dev.Selector()
+		if !sel.OnUSB() {
+			continue
+		}
+		go func() {
+			for ev := range usbwatch.Watch(ctx, sel) {
+				select {
+				case out <- cameraEvent{ev, c}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return out
+}
+
+func deviceNames(cfg config.Config) string {
+	var names []string
+	for _, dev := range cfg.ManagedDevices() {
+		names = append(names, fmt.Sprintf("%q", dev.Name))
+	}
+	return strings.Join(names, ",")
+}
+
+// reloadConfig re-reads the config file on SIGHUP. A file that doesn't load
+// or validate is rejected whole and the running config stays in effect.
+func reloadConfig(d *daemon, flags *daemonFlags) {
+	next, err := flags.load()
+	if err != nil {
+		log.Printf("SIGHUP: config reload failed, keeping current config: %v", err)
+		return
+	}
+	prev := d.config()
+	if next.Socket != prev.Socket {
+		log.Printf("SIGHUP: socket changed to %s; that takes effect on restart", next.Socket)
+		next.Socket = prev.Socket
+	}
+	if deviceNames(next) != deviceNames(prev) {
+		log.Printf("SIGHUP: device list changed to %s; that takes effect on restart", deviceNames(next))
+		next.Device, next.Devices = prev.Device, prev.Devices
+	} else {
+		for _, dev := range next.ManagedDevices() {
+			old, _ := prev.FindDevice(dev.Name)
+			if dev.ID != old.ID || dev.Serial != old.Serial || dev.Match != old.Match {
+				log.Printf("SIGHUP: %q selector changed; checks and resets use it now, USB arrival watching on restart", dev.Name)
+			}
+		}
+	}
+	d.setConfig(next)
+	log.Printf("SIGHUP: config reloaded (devices=%s, wake-delay=%s, retry=%s×%d, %d reset stages)",
+		deviceNames(next), next.Delays.Wake, next.Retry.Delay, next.Retry.Max, len(next.Reset.Stages))
+}
@@ -3,6 +3,10 @@ module github.com/phinze/camlink-fix
 go 1.24.5
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/ebitengine/purego v0.9.1
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/prashantgupta24/mac-sleep-notifier v1.0.1
 )
+
+require golang.org/x/sys v0.27.0 // indirect
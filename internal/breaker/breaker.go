@@ -0,0 +1,134 @@
+// Package breaker keeps the daemon from power-cycling a camera that keeps
+// wedging. Resetting a camera in a UVC interrupt storm (see
+// docs/edge-trigger-investigation.md) brings it back only for it to wedge
+// again, and every wake, arrival and retry would otherwise reset it anew.
+// A Breaker counts a camera's resets over a sliding window; once there are
+// too many it trips, and automatic resets stop until a cooldown has passed
+// or someone re-arms it by hand.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// Config tunes a Breaker.
+type Config struct {
+	// Threshold is how many resets within Window trip the breaker. Zero
+	// turns it off.
+	Threshold int
+	Window    time.Duration
+	// Cooldown is how long a tripped breaker holds off resets before it
+	// re-arms by itself.
+	Cooldown time.Duration
+}
+
+// State is a breaker's view of one camera at the moment it was asked.
+type State struct {
+	// Resets is how many resets were counted within the window; for a
+	// tripped breaker, up to when it tripped.
+	Resets    int           `json:"resets"`
+	Threshold int           `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	Tripped   bool          `json:"tripped"`
+	// TrippedAt and RearmAt are when the breaker tripped and when it will
+	// re-arm, if it's tripped.
+	TrippedAt time.Time `json:"tripped_at,omitzero"`
+	RearmAt   time.Time `json:"rearm_at,omitzero"`
+}
+
+// Breaker tracks one camera's resets. The zero value is ready to use. The
+// config is passed to each call rather than held, so a reload takes effect
+// straight away.
+type Breaker struct {
+	mu        sync.Mutex
+	resets    []time.Time
+	trippedAt time.Time
+}
+
+// Record counts a reset at now and reports whether it tripped the breaker.
+// Only the reset that trips it reports true, so the caller can say so once.
+func (b *Breaker) Record(cfg Config, now time.Time) bool {
+	if cfg.Threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cooled(cfg, now)
+	if !b.trippedAt.IsZero() {
+		return false
+	}
+	b.resets = append(b.inWindow(cfg, now), now)
+	if len(b.resets) < cfg.Threshold {
+		return false
+	}
+	b.trippedAt = now
+	return true
+}
+
+// Allow reports whether an automatic reset may go ahead at now: it may not
+// while the breaker is tripped. A breaker whose cooldown is up re-arms with
+// a clean slate first, and reports that in rearmed so the caller can say so.
+func (b *Breaker) Allow(cfg Config, now time.Time) (ok, rearmed bool) {
+	if cfg.Threshold <= 0 {
+		return true, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rearmed = b.cooled(cfg, now)
+	return b.trippedAt.IsZero(), rearmed
+}
+
+// Rearm closes the breaker and forgets the resets counted so far, reporting
+// whether it was tripped.
+func (b *Breaker) Rearm() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tripped := !b.trippedAt.IsZero()
+	b.resets, b.trippedAt = nil, time.Time{}
+	return tripped
+}
+
+// State reports the breaker's state at now.
+func (b *Breaker) State(cfg Config, now time.Time) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := State{Threshold: cfg.Threshold, Window: cfg.Window}
+	if cfg.Threshold <= 0 {
+		return st
+	}
+	b.cooled(cfg, now)
+	if !b.trippedAt.IsZero() {
+		st.Resets = len(b.resets)
+		st.Tripped = true
+		st.TrippedAt = b.trippedAt
+		st.RearmAt = b.trippedAt.Add(cfg.Cooldown)
+		return st
+	}
+	st.Resets = len(b.inWindow(cfg, now))
+	return st
+}
+
+// cooled re-arms a tripped breaker whose cooldown is up at now, reporting
+// whether it did. The resets that tripped it are forgotten with it; were
+// they kept, a window longer than the cooldown would trip it again on the
+// very next reset.
+func (b *Breaker) cooled(cfg Config, now time.Time) bool {
+	if b.trippedAt.IsZero() || now.Before(b.trippedAt.Add(cfg.Cooldown)) {
+		return false
+	}
+	b.resets, b.trippedAt = nil, time.Time{}
+	return true
+}
+
+// inWindow drops the resets that have slid out of the window ending at now
+// and returns the rest.
+func (b *Breaker) inWindow(cfg Config, now time.Time) []time.Time {
+	start := now.Add(-cfg.Window)
+	i := 0
+	for i < len(b.resets) && !b.resets[i].After(start) {
+		i++
+	}
+	b.resets = b.resets[i:]
+	return b.resets
+}
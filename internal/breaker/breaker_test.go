@@ -0,0 +1,100 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	cfg   = Config{Threshold: 3, Window: time.Hour, Cooldown: 2 * time.Hour}
+	start = time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+)
+
+func TestTripsAtThreshold(t *testing.T) {
+	var b Breaker
+	for i, at := range []time.Duration{0, 10 * time.Minute} {
+		if b.Record(cfg, start.Add(at)) {
+			t.Fatalf("reset %d tripped the breaker", i+1)
+		}
+	}
+	if ok, _ := b.Allow(cfg, start.Add(20*time.Minute)); !ok {
+		t.Fatal("breaker held off a reset before tripping")
+	}
+	if !b.Record(cfg, start.Add(20*time.Minute)) {
+		t.Fatal("third reset in the window didn't trip the breaker")
+	}
+	if b.Record(cfg, start.Add(25*time.Minute)) {
+		t.Error("a tripped breaker reported tripping again")
+	}
+
+	st := b.State(cfg, start.Add(30*time.Minute))
+	if !st.Tripped || st.Resets != 3 || !st.TrippedAt.Equal(start.Add(20*time.Minute)) ||
+		!st.RearmAt.Equal(start.Add(140*time.Minute)) {
+		t.Errorf("State = %+v", st)
+	}
+	if ok, _ := b.Allow(cfg, start.Add(30*time.Minute)); ok {
+		t.Error("tripped breaker allowed a reset")
+	}
+}
+
+func TestWindowSlides(t *testing.T) {
+	var b Breaker
+	// Three resets, but never three within an hour of each other.
+	for _, at := range []time.Duration{0, 40 * time.Minute, 70 * time.Minute, 105 * time.Minute} {
+		if b.Record(cfg, start.Add(at)) {
+			t.Fatalf("reset at +%s tripped the breaker", at)
+		}
+	}
+	if st := b.State(cfg, start.Add(110*time.Minute)); st.Tripped || st.Resets != 2 {
+		t.Errorf("State = %+v, want 2 resets in the window", st)
+	}
+}
+
+func TestCooldownRearms(t *testing.T) {
+	var b Breaker
+	for i := range 3 {
+		b.Record(cfg, start.Add(time.Duration(i)*time.Minute))
+	}
+	if ok, rearmed := b.Allow(cfg, start.Add(time.Hour)); ok || rearmed {
+		t.Fatalf("Allow mid-cooldown = %v, %v; want held off", ok, rearmed)
+	}
+	if ok, rearmed := b.Allow(cfg, start.Add(2*time.Hour+2*time.Minute)); !ok || !rearmed {
+		t.Fatalf("Allow after cooldown = %v, %v; want re-armed", ok, rearmed)
+	}
+	// It starts over: the resets that tripped it no longer count.
+	if b.Record(cfg, start.Add(2*time.Hour+3*time.Minute)) {
+		t.Error("first reset after re-arming tripped the breaker")
+	}
+	if st := b.State(cfg, start.Add(2*time.Hour+4*time.Minute)); st.Tripped || st.Resets != 1 {
+		t.Errorf("State = %+v, want 1 reset", st)
+	}
+}
+
+func TestRearm(t *testing.T) {
+	var b Breaker
+	if b.Rearm() {
+		t.Error("Rearm of an untripped breaker reported it tripped")
+	}
+	for i := range 3 {
+		b.Record(cfg, start.Add(time.Duration(i)*time.Minute))
+	}
+	if !b.Rearm() {
+		t.Error("Rearm of a tripped breaker reported it wasn't")
+	}
+	if ok, _ := b.Allow(cfg, start.Add(5*time.Minute)); !ok {
+		t.Error("re-armed breaker held off a reset")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var b Breaker
+	off := Config{Window: time.Hour, Cooldown: time.Hour}
+	for i := range 10 {
+		if b.Record(off, start.Add(time.Duration(i)*time.Minute)) {
+			t.Fatal("breaker with no threshold tripped")
+		}
+	}
+	if ok, _ := b.Allow(off, start.Add(10*time.Minute)); !ok {
+		t.Error("breaker with no threshold held off a reset")
+	}
+}
@@ -0,0 +1,10 @@
+package camwatch
+
+// Event describes a camera-open observed on the log stream.
+type Event struct {
+	// Process is the app that opened the camera subsystem, e.g. "Photo Booth".
+	Process string
+	// Signal is which CMIO marker fired: "cold-start", "warm-open", or
+	// "device-control".
+	Signal string
+}
@@ -53,15 +53,6 @@ func signalFor(msg string) string {
 // (process, signal).
 const debounceWindow = 3 * time.Second
 
-// Event describes a camera-open observed on the log stream.
-type Event struct {
-	// Process is the app that opened the camera subsystem, e.g. "Photo Booth".
-	Process string
-	// Signal is which CMIO marker fired: "cold-start", "warm-open", or
-	// "device-control".
-	Signal string
-}
-
 // logEntry is the subset of `log stream --style ndjson` fields we care about.
 // process is often null in ndjson, so we fall back to processImagePath.
 type logEntry struct {
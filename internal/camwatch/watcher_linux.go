@@ -0,0 +1,15 @@
+package camwatch
+
+import (
+	"context"
+	"log"
+)
+
+// Watch returns a channel that never receives on Linux. The CMIO markers
+// camwatch tails only exist in the macOS unified log, and since camwatch is
+// observe-only there's nothing to lose by going without. The channel is
+// returned (rather than nil) so callers can select on it unconditionally.
+func Watch(ctx context.Context) <-chan Event {
+	log.Println("camwatch: camera-open observation is macOS-only, disabled")
+	return make(chan Event)
+}
@@ -0,0 +1,577 @@
+// Package config loads the daemon's TOML configuration file. Every setting
+// has a built-in default and a matching command-line flag; the effective
+// config is defaults, then the file, then any flags given explicitly.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/phinze/camlink-fix/internal/breaker"
+	"github.com/phinze/camlink-fix/internal/control"
+	"github.com/phinze/camlink-fix/internal/device"
+	"github.com/phinze/camlink-fix/internal/health"
+	"github.com/phinze/camlink-fix/internal/history"
+	"github.com/phinze/camlink-fix/internal/profile"
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+// Config is everything the daemon can be told.
+type Config struct {
+	UhubctlPath string `toml:"uhubctl_path"`
+	// PortControl is how hub ports are switched: "auto", "uhubctl" or
+	// "sysfs"; see reset.PortControl.
+	PortControl string `toml:"port_control"`
+	FFmpegPath  string `toml:"ffmpeg_path"`
+	Notify      bool   `toml:"notify"`
+	// Socket is read at startup only; changing it needs a restart.
+	Socket string `toml:"socket"`
+	// History is the file every check/reset cycle is appended to; see
+	// package history. Empty turns the history off.
+	History string `toml:"history"`
+
+	// Device is the camera to look after. To look after several, list them
+	// in Devices instead.
+	Device  Device   `toml:"device,omitempty"`
+	Devices []Device `toml:"devices,omitempty"`
+	// Profiles are device profiles of the file's own, keyed by the name
+	// a device's profile setting picks them by. One named like a built-in
+	// profile takes its place.
+	Profiles map[string]Profile `toml:"profiles,omitempty"`
+
+	Health  Health  `toml:"health"`
+	Delays  Delays  `toml:"delays"`
+	Retry   Retry   `toml:"retry"`
+	Reset   Reset   `toml:"reset"`
+	Breaker Breaker `toml:"breaker"`
+}
+
+// Device identifies a camera and how to check it.
+type Device struct {
+	// Profile names the device profile, built in (see package profile) or
+	// from Config.Profiles, that Name, ID, NoSignalMode and Stages default
+	// from when they aren't set.
+	Profile string `toml:"profile,omitempty"`
+	// Name as shown in system_profiler (macOS) or video4linux (Linux). Used
+	// in logs and notifications, and to address the device over the control
+	// socket; the camera is found by ID, Serial and Match.
+	Name string `toml:"name"`
+	// ID is the USB vendor:product pair, e.g. "0fd9:007b".
+	ID string `toml:"id"`
+	// Serial, if set, picks one of several devices with the same ID.
+	Serial string `toml:"serial"`
+	// Match, if set, is a regexp the device's manufacturer and product
+	// strings must match.
+	Match          string `toml:"match"`
+	CaptureBackend string `toml:"capture_backend"`
+	// NoSignalMode is the mode the device advertises with no HDMI input.
+	NoSignalMode string `toml:"no_signal_mode"`
+	// Notify overrides the top-level notify setting for this device.
+	Notify *bool `toml:"notify,omitempty"`
+	// Stages, if set, is the reset ladder for this device in place of the
+	// top-level one.
+	Stages []Stage `toml:"stages,omitempty"`
+}
+
+// Profile is a device profile defined in the config file; see
+// profile.Profile.
+type Profile struct {
+	// Name is the DeviceName the profile gives a device.
+	Name         string  `toml:"name"`
+	ID           string  `toml:"id,omitempty"`
+	NotUSB       bool    `toml:"not_usb,omitempty"`
+	NoSignalMode string  `toml:"no_signal_mode,omitempty"`
+	Stages       []Stage `toml:"stages,omitempty"`
+}
+
+// profiles converts c.Profiles to the profile package's form, in name
+// order.
+func (c Config) profiles() []profile.Profile {
+	var out []profile.Profile
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		p := c.Profiles[name]
+		var stages []reset.Stage
+		for _, s := range p.Stages {
+			stages = append(stages, s.reset())
+		}
+		out = append(out, profile.Profile{Name: name, DeviceName: p.Name, ID: p.ID, NotUSB: p.NotUSB, NoSignalMode: p.NoSignalMode, Stages: stages})
+	}
+	return out
+}
+
+// Health tunes the health check.
+type Health struct {
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// Delays are how long to let things settle after each trigger before the
+// first health check.
+type Delays struct {
+	Startup    time.Duration `toml:"startup"`
+	Wake       time.Duration `toml:"wake"`
+	USBArrival time.Duration `toml:"usb_arrival"`
+}
+
+// Retry controls the loop entered when a reset doesn't fix the camera.
+type Retry struct {
+	Delay time.Duration `toml:"delay"`
+	Max   int           `toml:"max"`
+}
+
+// Breaker stops automatic resets of a camera that keeps wedging; see
+// package breaker.
+type Breaker struct {
+	// Threshold is how many resets within Window trip it. Zero turns it
+	// off.
+	Threshold int           `toml:"threshold"`
+	Window    time.Duration `toml:"window"`
+	Cooldown  time.Duration `toml:"cooldown"`
+}
+
+// Reset configures the escalating reset ladder.
+type Reset struct {
+	// AllowUnsafe runs power stages the blast-radius check refuses: ones
+	// that would cut power to other devices, or on hubs that report they
+	// can't switch port power.
+	AllowUnsafe bool    `toml:"allow_unsafe"`
+	Stages      []Stage `toml:"stages"`
+}
+
+// Stage is one rung of the reset ladder; see reset.Stage.
+type Stage struct {
+	Name string `toml:"name"`
+	// Kind is the reset strategy, one of reset.Kinds(). Empty means a port
+	// cycle, or with BothPorts, a both-ports cycle.
+	Kind string        `toml:"kind,omitempty"`
+	Off  time.Duration `toml:"off,omitempty"`
+	// BothPorts is the older way of asking for a both-ports cycle, kept so
+	// existing config files load. Only valid without a Kind.
+	BothPorts bool          `toml:"both_ports,omitempty"`
+	Settle    time.Duration `toml:"settle"`
+}
+
+// reset converts s to the reset package's form.
+func (s Stage) reset() reset.Stage {
+	kind := s.Kind
+	if kind == "" {
+		kind = reset.KindPortCycle
+		if s.BothPorts {
+			kind = reset.KindBothPortsCycle
+		}
+	}
+	return reset.Stage{Name: s.Name, Kind: kind, OffTime: s.Off, Settle: s.Settle}
+}
+
+// Default returns the built-in configuration.
+func Default() Config {
+	c := Config{
+		UhubctlPath: "uhubctl",
+		PortControl: string(reset.PortControlAuto),
+		FFmpegPath:  "ffmpeg",
+		Notify:      true,
+		Socket:      control.DefaultSocketPath(),
+		History:     history.DefaultPath(),
+		Device: Device{
+			Profile:        profile.Default,
+			CaptureBackend: string(health.DefaultBackend()),
+		},
+		Health: Health{Timeout: 3 * time.Second},
+		Delays: Delays{
+			Startup:    2 * time.Second,
+			Wake:       5 * time.Second,
+			USBArrival: 2 * time.Second,
+		},
+		Retry: Retry{Delay: 30 * time.Second, Max: 10},
+		Reset: Reset{Stages: stagesFrom(reset.DefaultStages)},
+		// One retry loop gone the distance is max+1 resets; leave room
+		// for that and then some before deciding the camera is flapping.
+		Breaker: Breaker{Threshold: 15, Window: time.Hour, Cooldown: time.Hour},
+	}
+	c.Device.applyProfile(nil, func(string) bool { return false })
+	return c
+}
+
+func stagesFrom(stages []reset.Stage) []Stage {
+	out := make([]Stage, len(stages))
+	for i, s := range stages {
+		out[i] = Stage{Name: s.Name, Kind: s.Kind, Off: s.OffTime, Settle: s.Settle}
+	}
+	return out
+}
+
+// DefaultPath is $XDG_CONFIG_HOME/camlink-fix/config.toml, falling back to
+// ~/.config when XDG_CONFIG_HOME is unset — on macOS too, rather than
+// ~/Library/Application Support, so dotfiles can manage it the same way.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "camlink-fix", "config.toml")
+}
+
+// Load reads the config file at path over the defaults and validates the
+// result. A missing file is only an error if mustExist is set, so the daemon
+// runs fine without one at the default location.
+func Load(path string, mustExist bool) (Config, error) {
+	return LoadProfile(path, mustExist, "")
+}
+
+// LoadProfile is Load with [device]'s profile set to profileName, as the
+// --profile flag does, whatever the file says. Settings the file gives
+// explicitly still override the profile's. An empty profileName leaves the
+// profile to the file.
+func LoadProfile(path string, mustExist bool, profileName string) (Config, error) {
+	c := Default()
+	data, err := os.ReadFile(path)
+	switch {
+	case path == "" || errors.Is(err, os.ErrNotExist) && !mustExist:
+		data, err = nil, nil
+	case err != nil:
+		return Config{}, err
+	}
+	// Errors name the file, if there is one.
+	wrap := func(err error) error {
+		if data == nil {
+			return err
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// A file that sets [[reset.stages]] replaces the default ladder rather
+	// than appending to it.
+	c.Reset.Stages = nil
+	c.Device.Stages = nil
+	md, err := toml.Decode(string(data), &c)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return Config{}, fmt.Errorf("%s: unknown setting(s): %s", path, strings.Join(keys, ", "))
+	}
+	if !md.IsDefined("reset", "stages") {
+		c.Reset.Stages = Default().Reset.Stages
+	}
+	if md.IsDefined("device") && md.IsDefined("devices") {
+		return Config{}, fmt.Errorf("%s: set either [device] or [[devices]], not both", path)
+	}
+
+	// Fill in whatever the file left to each device's profile. A top-level
+	// ladder in the file beats any profile's.
+	if profileName != "" {
+		c.Device.Profile = profileName
+	}
+	ladder := md.IsDefined("reset", "stages")
+	profiles := c.profiles()
+	err = c.Device.applyProfile(profiles, func(key string) bool {
+		return md.IsDefined("device", key) || key == "stages" && ladder
+	})
+	if err != nil {
+		return Config{}, wrap(fmt.Errorf("device.%w", err))
+	}
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		err := d.applyProfile(profiles, func(key string) bool {
+			switch key {
+			case "name":
+				return d.Name != ""
+			case "id":
+				return d.ID != ""
+			case "no_signal_mode":
+				return d.NoSignalMode != ""
+			default:
+				return len(d.Stages) > 0 || ladder
+			}
+		})
+		if err != nil {
+			return Config{}, wrap(fmt.Errorf("devices[%d].%w", i, err))
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, wrap(err)
+	}
+	return c, nil
+}
+
+// Validate reports the first setting that can't work.
+func (c Config) Validate() error {
+	switch {
+	case c.UhubctlPath == "":
+		return errors.New("uhubctl_path: must not be empty")
+	case c.FFmpegPath == "":
+		return errors.New("ffmpeg_path: must not be empty")
+	case c.Health.Timeout <= 0:
+		return fmt.Errorf("health.timeout: must be positive, got %s", c.Health.Timeout)
+	case c.Delays.Startup < 0:
+		return fmt.Errorf("delays.startup: must not be negative, got %s", c.Delays.Startup)
+	case c.Delays.Wake < 0:
+		return fmt.Errorf("delays.wake: must not be negative, got %s", c.Delays.Wake)
+	case c.Delays.USBArrival < 0:
+		return fmt.Errorf("delays.usb_arrival: must not be negative, got %s", c.Delays.USBArrival)
+	case c.Retry.Delay <= 0:
+		return fmt.Errorf("retry.delay: must be positive, got %s", c.Retry.Delay)
+	case c.Retry.Max < 0:
+		return fmt.Errorf("retry.max: must not be negative, got %d", c.Retry.Max)
+	case c.Breaker.Threshold < 0:
+		return fmt.Errorf("breaker.threshold: must not be negative, got %d", c.Breaker.Threshold)
+	case c.Breaker.Window <= 0:
+		return fmt.Errorf("breaker.window: must be positive, got %s", c.Breaker.Window)
+	case c.Breaker.Cooldown <= 0:
+		return fmt.Errorf("breaker.cooldown: must be positive, got %s", c.Breaker.Cooldown)
+	}
+	if _, err := reset.ParsePortControl(c.PortControl); err != nil {
+		return fmt.Errorf("port_control: %w", err)
+	}
+	if err := validateStages(c.Reset.Stages); err != nil {
+		return fmt.Errorf("reset.%w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		if err := c.Profiles[name].validate(); err != nil {
+			return fmt.Errorf("profiles.%s.%w", name, err)
+		}
+	}
+
+	profiles := c.profiles()
+	if len(c.Devices) == 0 {
+		if err := c.Device.validate(profiles); err != nil {
+			return fmt.Errorf("device.%w", err)
+		}
+	}
+	names := map[string]int{}
+	selectors := map[string]int{}
+	for i, d := range c.Devices {
+		if err := d.validate(profiles); err != nil {
+			return fmt.Errorf("devices[%d].%w", i, err)
+		}
+		if j, dup := names[d.Name]; dup {
+			return fmt.Errorf("devices[%d].name: %q is already used by devices[%d]", i, d.Name, j)
+		}
+		names[d.Name] = i
+		sel, _ := d.Selector()
+		if !sel.OnUSB() {
+			continue
+		}
+		if j, dup := selectors[sel.String()]; dup {
+			return fmt.Errorf("devices[%d]: selects the same device as devices[%d] (%s); add a serial or match to tell them apart", i, j, sel)
+		}
+		selectors[sel.String()] = i
+	}
+	return nil
+}
+
+// validateStages checks a reset ladder. Errors start at "stages", for the
+// caller to prefix.
+func validateStages(stages []Stage) error {
+	if len(stages) == 0 {
+		return errors.New("stages: need at least one stage")
+	}
+	for i, s := range stages {
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("stages[%d].name: must not be empty", i)
+		case s.Kind != "" && s.BothPorts:
+			return fmt.Errorf("stages[%d].both_ports (%s): only valid without a kind; use kind = %q", i, s.Name, reset.KindBothPortsCycle)
+		case s.Settle < 0:
+			return fmt.Errorf("stages[%d].settle (%s): must not be negative, got %s", i, s.Name, s.Settle)
+		}
+		if _, err := s.reset().Strategy(); err != nil {
+			var pe *reset.ParamError
+			if errors.As(err, &pe) {
+				return fmt.Errorf("stages[%d].%s (%s): %s", i, pe.Param, s.Name, pe.Msg)
+			}
+			return fmt.Errorf("stages[%d] (%s): %w", i, s.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a profile from the file. Errors name the field without
+// the "profiles.<name>." prefix, which the caller adds.
+func (p Profile) validate() error {
+	if p.ID != "" {
+		if p.NotUSB {
+			return errors.New("id: must not be set for a device that isn't on USB")
+		}
+		if _, _, err := device.ParseID(p.ID); err != nil {
+			return fmt.Errorf("id: %w", err)
+		}
+	}
+	if p.NoSignalMode != "" && !validMode(p.NoSignalMode) {
+		return fmt.Errorf("no_signal_mode: %q is not WxH or WxH@rate", p.NoSignalMode)
+	}
+	if p.Stages != nil {
+		return validateStages(p.Stages)
+	}
+	return nil
+}
+
+// validate checks one device's settings, with the file's own profiles
+// alongside the built-in ones. Errors name the field without the "device."
+// or "devices[N]." prefix, which the caller adds.
+func (d Device) validate(profiles []profile.Profile) error {
+	if d.Name == "" {
+		return errors.New("name: must not be empty")
+	}
+	var p profile.Profile
+	if d.Profile != "" {
+		var err error
+		if p, err = profile.Lookup(d.Profile, profiles); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	if p.NotUSB {
+		if d.ID != "" {
+			return fmt.Errorf("id: the %s profile is for a device that isn't on USB", p.Name)
+		}
+	} else if _, _, err := device.ParseID(d.ID); err != nil {
+		return fmt.Errorf("id: %w", err)
+	}
+	if _, err := d.Selector(); err != nil {
+		return fmt.Errorf("match: %w", err)
+	}
+	if _, err := health.ParseBackend(d.CaptureBackend); err != nil {
+		return fmt.Errorf("capture_backend: %w", err)
+	}
+	if d.NoSignalMode != "" && !validMode(d.NoSignalMode) {
+		return fmt.Errorf("no_signal_mode: %q is not WxH or WxH@rate", d.NoSignalMode)
+	}
+	if d.Stages != nil {
+		return validateStages(d.Stages)
+	}
+	return nil
+}
+
+// applyProfile fills in the settings d's profile, from profiles or the
+// built-in ones, supplies, except those isSet reports as given explicitly
+// (by TOML key).
+func (d *Device) applyProfile(profiles []profile.Profile, isSet func(key string) bool) error {
+	if d.Profile == "" {
+		return nil
+	}
+	p, err := profile.Lookup(d.Profile, profiles)
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	if !isSet("name") {
+		d.Name = p.DeviceName
+	}
+	if !isSet("id") {
+		d.ID = p.ID
+	}
+	if !isSet("no_signal_mode") {
+		d.NoSignalMode = p.NoSignalMode
+	}
+	if !isSet("stages") && p.Stages != nil {
+		d.Stages = stagesFrom(p.Stages)
+	}
+	return nil
+}
+
+func validMode(s string) bool {
+	size, _, _ := strings.Cut(s, "@")
+	var w, h int
+	n, err := fmt.Sscanf(size, "%dx%d", &w, &h)
+	return err == nil && n == 2 && w > 0 && h > 0
+}
+
+// Selector returns which USB device d is.
+func (d Device) Selector() (device.Selector, error) {
+	return device.New(d.ID, d.Serial, d.Match)
+}
+
+// ManagedDevices returns the cameras to look after: Devices if any are
+// listed, otherwise just Device.
+func (c Config) ManagedDevices() []Device {
+	if len(c.Devices) > 0 {
+		return c.Devices
+	}
+	return []Device{c.Device}
+}
+
+// FindDevice returns the managed device called name.
+func (c Config) FindDevice(name string) (Device, bool) {
+	for _, d := range c.ManagedDevices() {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// NotifyFor reports whether to send notifications about d.
+func (c Config) NotifyFor(d Device) bool {
+	if d.Notify != nil {
+		return *d.Notify
+	}
+	return c.Notify
+}
+
+// HealthConfig returns the health-check settings for d.
+func (c Config) HealthConfig(d Device) health.Config {
+	backend, _ := health.ParseBackend(d.CaptureBackend)
+	sel, _ := d.Selector()
+	return health.Config{
+		FFmpegPath:   c.FFmpegPath,
+		DeviceName:   d.Name,
+		Selector:     sel,
+		Timeout:      c.Health.Timeout,
+		Backend:      backend,
+		NoSignalMode: d.NoSignalMode,
+	}
+}
+
+// ResetEnv returns what reset strategies need to act on the system.
+func (c Config) ResetEnv() reset.Env {
+	pc, _ := reset.ParsePortControl(c.PortControl)
+	return reset.Env{UhubctlPath: c.UhubctlPath, PortControl: pc, AllowUnsafe: c.Reset.AllowUnsafe}
+}
+
+// BreakerConfig returns the circuit breaker settings.
+func (c Config) BreakerConfig() breaker.Config {
+	return breaker.Config{Threshold: c.Breaker.Threshold, Window: c.Breaker.Window, Cooldown: c.Breaker.Cooldown}
+}
+
+// ResetStages returns the reset ladder for d: its own if it has one,
+// otherwise the top-level one.
+func (c Config) ResetStages(d Device) []reset.Stage {
+	src := c.Reset.Stages
+	if len(d.Stages) > 0 {
+		src = d.Stages
+	}
+	stages := make([]reset.Stage, len(src))
+	for i, s := range src {
+		stages[i] = s.reset()
+	}
+	return stages
+}
+
+// Encode renders c as TOML, in the same shape Load reads. With [[devices]],
+// the unused [device] is left out, since Load won't take both.
+func (c Config) Encode() ([]byte, error) {
+	if len(c.Devices) > 0 {
+		c.Device = Device{}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
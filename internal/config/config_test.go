@@ -0,0 +1,341 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nope.toml")
+
+	c, err := Load(path, false)
+	if err != nil {
+		t.Fatalf("Load(missing, false): %v", err)
+	}
+	if len(c.Reset.Stages) != len(Default().Reset.Stages) {
+		t.Errorf("missing file should give defaults, got %d stages", len(c.Reset.Stages))
+	}
+
+	if _, err := Load(path, true); err == nil {
+		t.Error("Load(missing, true) should fail")
+	}
+}
+
+func TestLoadOverlaysDefaults(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+[delays]
+wake = "8s"
+
+[retry]
+max = 3
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Delays.Wake != 8*time.Second {
+		t.Errorf("delays.wake = %s, want 8s", c.Delays.Wake)
+	}
+	if c.Retry.Max != 3 {
+		t.Errorf("retry.max = %d, want 3", c.Retry.Max)
+	}
+	def := Default()
+	if c.Delays.USBArrival != def.Delays.USBArrival || c.Retry.Delay != def.Retry.Delay {
+		t.Errorf("unset settings should keep their defaults, got %+v %+v", c.Delays, c.Retry)
+	}
+	if len(c.Reset.Stages) != len(def.Reset.Stages) {
+		t.Errorf("unset reset.stages should keep the default ladder, got %d stages", len(c.Reset.Stages))
+	}
+}
+
+func TestLoadStagesReplaceDefaults(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+[[reset.stages]]
+name = "only"
+off = "4s"
+both_ports = true
+settle = "1s"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := c.ResetStages(c.Device)
+	if len(stages) != 1 {
+		t.Fatalf("got %d stages, want 1", len(stages))
+	}
+	if s := stages[0]; s.Name != "only" || s.OffTime != 4*time.Second || s.Kind != reset.KindBothPortsCycle || s.Settle != time.Second {
+		t.Errorf("stage = %+v", s)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"unknown key", "wake_delay = \"5s\"\n", "unknown setting(s): wake_delay"},
+		{"bad duration", "[health]\ntimeout = \"soon\"\n", "timeout"},
+		{"zero timeout", "[health]\ntimeout = \"0s\"\n", "health.timeout: must be positive"},
+		{"negative delay", "[delays]\nwake = \"-1s\"\n", "delays.wake: must not be negative"},
+		{"negative threshold", "[breaker]\nthreshold = -1\n", "breaker.threshold: must not be negative"},
+		{"zero window", "[breaker]\nwindow = \"0s\"\n", "breaker.window: must be positive"},
+		{"bad port control", "port_control = \"usbip\"\n", "port_control: unknown port control"},
+		{"bad device id", "[device]\nid = \"0fd9\"\n", "device.id: invalid USB ID"},
+		{"bad match", "[device]\nmatch = \"Cam (Link\"\n", "device.match: invalid match pattern"},
+		{"bad backend", "[device]\ncapture_backend = \"dshow\"\n", "device.capture_backend"},
+		{"bad mode", "[device]\nno_signal_mode = \"4k\"\n", "device.no_signal_mode"},
+		{"empty ladder", "[reset]\nstages = []\n", "reset.stages: need at least one stage"},
+		{"stage without off", "[[reset.stages]]\nname = \"x\"\n", "reset.stages[0].off (x): must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.body), true)
+			if err == nil {
+				t.Fatal("Load succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrips(t *testing.T) {
+	want := Default()
+	want.Delays.Wake = 12 * time.Second
+	want.Reset.Stages = want.Reset.Stages[:1]
+
+	out, err := want.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(writeConfig(t, string(out)), true)
+	if err != nil {
+		t.Fatalf("Load(Encode()): %v\n%s", err, out)
+	}
+	if got.Delays.Wake != want.Delays.Wake || len(got.Reset.Stages) != 1 || got.Reset.Stages[0] != want.Reset.Stages[0] {
+		t.Errorf("round trip lost settings:\n%s", out)
+	}
+
+	// With several cameras, only [[devices]] goes out.
+	multi := Default()
+	multi.Devices = []Device{
+		{Name: "Cam Link A", ID: "0fd9:007b", Serial: "0005AAAA0001", CaptureBackend: multi.Device.CaptureBackend},
+		{Name: "HD60 S+", ID: "0fd9:006d", NoSignalMode: "1920x1080@60", CaptureBackend: multi.Device.CaptureBackend},
+	}
+	out, err = multi.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = Load(writeConfig(t, string(out)), true)
+	if err != nil {
+		t.Fatalf("Load(Encode()) with [[devices]]: %v\n%s", err, out)
+	}
+	if devs := got.ManagedDevices(); len(devs) != 2 || devs[0].Serial != "0005AAAA0001" || devs[1].NoSignalMode != "1920x1080@60" {
+		t.Errorf("round trip lost devices: %+v\n%s", devs, out)
+	}
+}
+
+func TestLoadDevices(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+[[devices]]
+name = "Cam Link A"
+id = "0fd9:007b"
+serial = "0005AAAA0001"
+
+[[devices]]
+name = "Cam Link B"
+id = "0fd9:007b"
+serial = "0005BBBB0002"
+notify = false
+
+[[devices]]
+name = "HD60 S+"
+id = "0fd9:006d"
+no_signal_mode = "1920x1080@60"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devs := c.ManagedDevices()
+	if len(devs) != 3 {
+		t.Fatalf("got %d devices, want 3", len(devs))
+	}
+	b, ok := c.FindDevice("Cam Link B")
+	if !ok || b.Serial != "0005BBBB0002" {
+		t.Errorf("FindDevice(Cam Link B) = %+v, %v", b, ok)
+	}
+	if c.NotifyFor(b) || !c.NotifyFor(devs[0]) {
+		t.Error("per-device notify override not applied")
+	}
+	if hc := c.HealthConfig(devs[2]); hc.DeviceName != "HD60 S+" || hc.NoSignalMode != "1920x1080@60" || hc.Selector.ProductID != 0x006d {
+		t.Errorf("HealthConfig(HD60 S+) = %+v", hc)
+	}
+
+	// Without [[devices]], the single [device] is the one managed.
+	single := Default()
+	if devs := single.ManagedDevices(); len(devs) != 1 || devs[0].Name != "Cam Link 4K" {
+		t.Errorf("default ManagedDevices() = %+v", devs)
+	}
+}
+
+func TestLoadDevicesRejects(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"both forms", "[device]\nname = \"x\"\n[[devices]]\nname = \"y\"\nid = \"0fd9:007b\"\n", "either [device] or [[devices]]"},
+		{"duplicate name", "[[devices]]\nname = \"x\"\nid = \"0fd9:007b\"\nserial = \"1\"\n[[devices]]\nname = \"x\"\nid = \"0fd9:007b\"\nserial = \"2\"\n", `devices[1].name: "x" is already used`},
+		{"same selector", "[[devices]]\nname = \"x\"\nid = \"0fd9:007b\"\n[[devices]]\nname = \"y\"\nid = \"0fd9:007b\"\n", "devices[1]: selects the same device as devices[0]"},
+		{"missing id", "[[devices]]\nname = \"x\"\n", "devices[0].id: invalid USB ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.body), true)
+			if err == nil {
+				t.Fatal("Load succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+[device]
+profile = "hd60-s-plus"
+id = "0fd9:006d"
+no_signal_mode = "1920x1080@60"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Device; d.Name != "Game Capture HD60 S+" || d.ID != "0fd9:006d" || d.NoSignalMode != "1920x1080@60" {
+		t.Errorf("device = %+v, want the HD60 S+ profile with the file's no_signal_mode", d)
+	}
+	if stages := c.ResetStages(c.Device); len(stages) != 3 || stages[0].Settle != 6*time.Second {
+		t.Errorf("ResetStages() = %+v, want the profile's ladder", stages)
+	}
+
+	// --profile beats the file's profile, but not the file's other settings.
+	path := writeConfig(t, "[device]\nprofile = \"hd60-s-plus\"\nname = \"desk\"\n")
+	c, err = LoadProfile(path, true, "generic-uvc")
+	if err == nil || !strings.Contains(err.Error(), "device.id") {
+		t.Errorf("generic-uvc without an id: err = %v, want a device.id error", err)
+	}
+	c, err = LoadProfile(path, true, "camlink-4k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Device; d.Profile != "camlink-4k" || d.Name != "desk" || d.ID != "0fd9:007b" || d.Stages != nil {
+		t.Errorf("device = %+v, want camlink-4k named desk", d)
+	}
+
+	// A top-level ladder in the file beats the profile's.
+	c, err = Load(writeConfig(t, `
+[device]
+profile = "hd60-s-plus"
+id = "0fd9:006d"
+
+[[reset.stages]]
+name = "only"
+off = "4s"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stages := c.ResetStages(c.Device); len(stages) != 1 {
+		t.Errorf("ResetStages() = %+v, want the file's ladder", stages)
+	}
+
+	// [[devices]] entries take a profile each.
+	c, err = Load(writeConfig(t, `
+[[devices]]
+profile = "camlink-4k"
+
+[[devices]]
+profile = "camlink-pro"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pro, ok := c.FindDevice("Cam Link Pro"); !ok || pro.ID != "" {
+		t.Errorf("FindDevice(Cam Link Pro) = %+v, %v", pro, ok)
+	} else if sel, _ := pro.Selector(); sel.OnUSB() {
+		t.Errorf("Cam Link Pro selector %s is on USB", sel)
+	}
+
+	// The file can define profiles of its own, and replace built-in ones.
+	path = writeConfig(t, `
+[profiles.hd60-x]
+name = "Game Capture HD60 X"
+id = "0fd9:0082"
+no_signal_mode = "1920x1080@60"
+
+[[profiles.hd60-x.stages]]
+name = "slow cycle"
+off = "8s"
+settle = "12s"
+
+[profiles.camlink-4k]
+name = "Office Cam Link"
+id = "0fd9:007b"
+
+[[devices]]
+profile = "hd60-x"
+
+[[devices]]
+profile = "camlink-4k"
+`)
+	c, err = Load(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := c.FindDevice("Game Capture HD60 X"); !ok || d.ID != "0fd9:0082" || d.NoSignalMode != "1920x1080@60" ||
+		len(d.Stages) != 1 || d.Stages[0].Settle != 12*time.Second {
+		t.Errorf("FindDevice(Game Capture HD60 X) = %+v, %v; want the file's hd60-x profile", d, ok)
+	}
+	if d, ok := c.FindDevice("Office Cam Link"); !ok || d.NoSignalMode != "" {
+		t.Errorf("FindDevice(Office Cam Link) = %+v, %v; want the file's camlink-4k in place of the built-in one", d, ok)
+	}
+}
+
+func TestLoadProfileRejects(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"unknown", "[device]\nprofile = \"hd60\"\n", `device.profile: unknown profile "hd60"`},
+		{"id on non-USB", "[device]\nprofile = \"camlink-pro\"\nid = \"0fd9:007b\"\n", "device.id: the camlink-pro profile is for a device that isn't on USB"},
+		{"bad device stage", "[device]\n[[device.stages]]\nname = \"x\"\n", "device.stages[0].off (x): must be positive"},
+		{"bad profile id", "[profiles.mine]\nname = \"Mine\"\nid = \"0fd9\"\n", `profiles.mine.id: invalid USB ID "0fd9"`},
+		{"id on non-USB profile", "[profiles.mine]\nname = \"Mine\"\nid = \"0fd9:007b\"\nnot_usb = true\n", "profiles.mine.id: must not be set"},
+		{"bad profile stage", "[profiles.mine]\nname = \"Mine\"\n[[profiles.mine.stages]]\nname = \"x\"\n", "profiles.mine.stages[0].off (x): must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.body), true)
+			if err == nil {
+				t.Fatal("Load succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
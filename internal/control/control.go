@@ -0,0 +1,160 @@
+// Package control is the daemon's local control channel: a per-user Unix
+// socket speaking one JSON request and one JSON response per connection.
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+)
+
+// Commands understood by the daemon.
+const (
+	// CmdCheck runs a health check and resets the camera if it's wedged.
+	CmdCheck = "check"
+	// CmdStatus reports what the daemon currently knows.
+	CmdStatus = "status"
+	// CmdHeal powers back on the ports an interrupted reset left off, if
+	// the reset journal has any from this boot, then checks the camera.
+	CmdHeal = "heal"
+	// CmdReset power-cycles the camera regardless of its health.
+	CmdReset = "reset"
+	// CmdRearm closes a tripped circuit breaker, so automatic resets
+	// resume without waiting out its cooldown.
+	CmdRearm = "rearm"
+)
+
+// Request is one command sent to the daemon.
+type Request struct {
+	Command string `json:"command"`
+	// Device names the camera to act on. Empty means every camera the
+	// daemon supervises.
+	Device string `json:"device,omitempty"`
+}
+
+// Response is the daemon's answer. OK is false if the command failed or a
+// camera didn't end up healthy.
+//
+// A command addressed to one camera fills in Health or Status; a status
+// request covering several fills in Devices instead, and other commands
+// covering several report one Message line per camera.
+type Response struct {
+	OK      bool           `json:"ok"`
+	Message string         `json:"message,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Health  *health.Result `json:"health,omitempty"`
+	Status  *Status        `json:"status,omitempty"`
+	Devices []Status       `json:"devices,omitempty"`
+}
+
+// Handler executes one request. It may block for as long as the command
+// takes (a full reset ladder is over a minute); ctx is cancelled if the
+// daemon shuts down.
+type Handler func(ctx context.Context, req Request) Response
+
+// DefaultSocketPath returns the per-user socket location: under
+// $XDG_RUNTIME_DIR when there is one (Linux sessions), otherwise in the temp
+// dir with the uid in the name, since /tmp may be shared.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "camlink-fix.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("camlink-fix-%d.sock", os.Getuid()))
+}
+
+// Listen opens the control socket at path, readable and writable by the
+// owner only. A leftover socket from a daemon that died without cleaning up
+// is replaced; one that still answers is a live daemon, and an error.
+func Listen(path string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another camlink-fix daemon is listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing stale socket: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve accepts connections on ln until ctx is cancelled, handling each on
+// its own goroutine.
+func Serve(ctx context.Context, ln net.Listener, h Handler) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("control: accept failed: %v", err)
+			}
+			return
+		}
+		go serveConn(ctx, conn, h)
+	}
+}
+
+func serveConn(ctx context.Context, conn net.Conn, h Handler) {
+	defer conn.Close()
+
+	var req Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		writeResponse(conn, Response{Error: fmt.Sprintf("bad request: %v", err)})
+		return
+	}
+
+	// Status is polled by shell prompts and status bars; don't log those.
+	if req.Command != CmdStatus {
+		log.Printf("control: %s requested", req.Command)
+	}
+	writeResponse(conn, h(ctx, req))
+}
+
+func writeResponse(conn net.Conn, resp Response) {
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("control: could not write response: %v", err)
+	}
+}
+
+// Call sends req to the daemon listening at path and waits up to timeout for
+// its response. A zero timeout waits indefinitely.
+func Call(path string, req Request, timeout time.Duration) (Response, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("reading response: %w", err)
+	}
+	return resp, nil
+}
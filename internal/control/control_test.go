@@ -0,0 +1,70 @@
+package control
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+)
+
+func TestCallRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Serve(ctx, ln, func(_ context.Context, req Request) Response {
+		if req.Command != CmdCheck {
+			return Response{Error: "unexpected " + req.Command}
+		}
+		return Response{OK: false, Message: "camera is wedged", Health: &health.Result{
+			State:     health.StateWedged,
+			Signature: health.SigNoModes,
+		}}
+	})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket mode = %o, want 600", perm)
+	}
+
+	resp, err := Call(path, Request{Command: CmdCheck}, time.Second)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if resp.OK || resp.Health == nil || resp.Health.State != health.StateWedged || resp.Health.Signature != health.SigNoModes {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+
+	// A socket file with nobody behind it, as left by a killed daemon.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen over stale socket: %v", err)
+	}
+	defer ln.Close()
+
+	if _, err := Listen(path); err == nil {
+		t.Error("second Listen on a live socket succeeded")
+	}
+}
@@ -0,0 +1,50 @@
+package control
+
+import (
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/breaker"
+	"github.com/phinze/camlink-fix/internal/health"
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+// Status is the daemon's view of one camera at the moment it was asked.
+type Status struct {
+	// Device is the configured device name.
+	Device string `json:"device"`
+	// Present is whether the device is enumerated right now.
+	Present bool `json:"present"`
+
+	// LastCheck is the most recent health check result, from any trigger.
+	LastCheck *health.Result `json:"last_check,omitempty"`
+	// LastCheckAt is when LastCheck finished.
+	LastCheckAt time.Time `json:"last_check_at,omitzero"`
+	// LastTrigger names the event that prompted the last check, e.g.
+	// "wake" or "usb-arrival/retry-2".
+	LastTrigger string `json:"last_trigger,omitempty"`
+
+	// Resetting is whether a check/reset cycle is running right now.
+	Resetting bool `json:"resetting"`
+	// RetryAttempt is which retry the running cycle is on, 0 if it isn't
+	// retrying.
+	RetryAttempt int `json:"retry_attempt,omitempty"`
+	MaxRetries   int `json:"max_retries"`
+	// Queued names the triggers coalesced into the check that will run
+	// once the current cycle finishes, if any.
+	Queued []string `json:"queued,omitempty"`
+
+	// LastReset is what the most recent reset did, stage by stage, if one
+	// has run since the daemon started.
+	LastReset *reset.Report `json:"last_reset,omitempty"`
+	// LastResetAt is when LastReset finished.
+	LastResetAt time.Time `json:"last_reset_at,omitzero"`
+	// FixedBy counts, per reset stage, how many resets since the daemon
+	// started it brought the device back.
+	FixedBy map[string]int `json:"fixed_by,omitempty"`
+	// Breaker is the circuit breaker's count of recent resets, and whether
+	// it has tripped and stopped automatic ones. Nil if it's turned off.
+	Breaker *breaker.State `json:"breaker,omitempty"`
+
+	// Location is where the last reset found the device, if one has run.
+	Location *reset.SavedLocation `json:"location,omitempty"`
+}
@@ -0,0 +1,121 @@
+// Package device says which physical camera the daemon is looking after.
+// The same Selector is what usbwatch listens for, what reset looks for in
+// the hub tree and what health opens, so they can't disagree about which
+// device is meant.
+package device
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Elgato Cam Link 4K USB IDs
+const (
+	CamLinkVendorID  = 0x0fd9
+	CamLinkProductID = 0x007b
+)
+
+// Selector picks out one USB device. VendorID and ProductID are required;
+// Serial and Match narrow it down when several devices share them.
+//
+// A selector with no IDs is for a capture device that isn't on USB at all
+// (a PCIe card); it never matches anything on the bus, and the health check
+// falls back to finding the device by name.
+type Selector struct {
+	VendorID  uint16
+	ProductID uint16
+	// Serial, if set, must equal the device's iSerialNumber string.
+	Serial string
+	// Match, if set, must match somewhere in the device's manufacturer and
+	// product strings (e.g. "Elgato Cam Link 4K"). Platforms that only know
+	// the product name match against that.
+	Match *regexp.Regexp
+}
+
+// CamLink selects any Elgato Cam Link 4K.
+var CamLink = Selector{VendorID: CamLinkVendorID, ProductID: CamLinkProductID}
+
+// New builds a Selector from its config form: id as vvvv:pppp, and optional
+// serial and match regexp. An empty id builds a selector for a device that
+// isn't on USB.
+func New(id, serial, match string) (Selector, error) {
+	s := Selector{Serial: serial}
+	if id != "" {
+		vid, pid, err := ParseID(id)
+		if err != nil {
+			return Selector{}, err
+		}
+		s.VendorID, s.ProductID = vid, pid
+	}
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid match pattern: %w", err)
+		}
+		s.Match = re
+	}
+	return s, nil
+}
+
+// ParseID parses a vvvv:pppp vendor:product pair, as lsusb prints them.
+func ParseID(id string) (vendorID, productID uint16, err error) {
+	vendor, product, ok := strings.Cut(id, ":")
+	v, err1 := strconv.ParseUint(vendor, 16, 16)
+	p, err2 := strconv.ParseUint(product, 16, 16)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid USB ID %q, want vvvv:pppp", id)
+	}
+	return uint16(v), uint16(p), nil
+}
+
+// OnUSB reports whether s picks out a USB device, as opposed to one that
+// can only be found by name.
+func (s Selector) OnUSB() bool {
+	return s.VendorID != 0 || s.ProductID != 0
+}
+
+// ID returns the vendor:product pair as vvvv:pppp.
+func (s Selector) ID() string {
+	return fmt.Sprintf("%04x:%04x", s.VendorID, s.ProductID)
+}
+
+// String describes the selector for logs, e.g.
+// `0fd9:007b serial=000513A1B2C3 match="Cam Link"`.
+func (s Selector) String() string {
+	str := s.ID()
+	if !s.OnUSB() {
+		str = "not on USB"
+	}
+	if s.Serial != "" {
+		str += " serial=" + s.Serial
+	}
+	if s.Match != nil {
+		str += fmt.Sprintf(" match=%q", s.Match)
+	}
+	return str
+}
+
+// MatchesID reports whether vendorID:productID is the selected kind of
+// device. It's all that can be checked where serial and strings aren't
+// available, such as a departing device whose sysfs entry is already gone.
+func (s Selector) MatchesID(vendorID, productID uint16) bool {
+	return vendorID == s.VendorID && productID == s.ProductID
+}
+
+// Matches reports whether a device with these IDs and descriptor strings is
+// the selected one. description is the device's manufacturer and product
+// strings, space separated.
+func (s Selector) Matches(vendorID, productID uint16, serial, description string) bool {
+	if !s.MatchesID(vendorID, productID) {
+		return false
+	}
+	if s.Serial != "" && serial != s.Serial {
+		return false
+	}
+	if s.Match != nil && !s.Match.MatchString(description) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package device
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	s, err := New("0fd9:007B", "000513A1B2C3", "Cam Link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.VendorID != 0x0fd9 || s.ProductID != 0x007b {
+		t.Errorf("IDs = %04x:%04x", s.VendorID, s.ProductID)
+	}
+	if got, want := s.String(), `0fd9:007b serial=000513A1B2C3 match="Cam Link"`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	if s, err := New("", "", ""); err != nil || s.OnUSB() || s.String() != "not on USB" {
+		t.Errorf("New(\"\") = %v, %v; want a selector that's not on USB", s, err)
+	}
+
+	for _, bad := range []struct{ id, match string }{
+		{"0fd9", ""},
+		{"0fd9:zzzz", ""},
+		{"10000:007b", ""},
+		{"0fd9:007b", "Cam (Link"},
+	} {
+		if _, err := New(bad.id, "", bad.match); err == nil {
+			t.Errorf("New(%q, %q) should fail", bad.id, bad.match)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	any, _ := New("0fd9:007b", "", "")
+	bySerial, _ := New("0fd9:007b", "B", "")
+	byName, _ := New("0fd9:006d", "", `HD60 S\+`)
+
+	tests := []struct {
+		sel         Selector
+		vid, pid    uint16
+		serial, str string
+		want        bool
+	}{
+		{any, 0x0fd9, 0x007b, "A", "Elgato Cam Link 4K", true},
+		{any, 0x0fd9, 0x006d, "A", "Elgato Game Capture HD60 S+", false},
+		{bySerial, 0x0fd9, 0x007b, "A", "Elgato Cam Link 4K", false},
+		{bySerial, 0x0fd9, 0x007b, "B", "Elgato Cam Link 4K", true},
+		{byName, 0x0fd9, 0x006d, "", "Elgato Game Capture HD60 S+", true},
+		{byName, 0x0fd9, 0x006d, "", "Elgato Game Capture HD60 S", false},
+	}
+	for _, tt := range tests {
+		if got := tt.sel.Matches(tt.vid, tt.pid, tt.serial, tt.str); got != tt.want {
+			t.Errorf("%s.Matches(%04x:%04x, %q, %q) = %v, want %v",
+				tt.sel, tt.vid, tt.pid, tt.serial, tt.str, got, tt.want)
+		}
+	}
+}
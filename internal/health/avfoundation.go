@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// avfoundationBackend locates cameras with system_profiler and opens them by
+// name through ffmpeg's avfoundation input.
+type avfoundationBackend struct{}
+
+// find checks whether the device appears in system_profiler output, and
+// returns it by the name ffmpeg knows it as.
+//
+// system_profiler shows a UVC camera's vendor and product IDs but not its
+// serial number, so a selector's serial can't be checked here; two cameras
+// of the same model are told apart only by a Match on their names.
+func (avfoundationBackend) find(cfg Config) (device, bool) {
+	out, err := exec.Command("system_profiler", "SPCameraDataType").Output()
+	if err != nil {
+		log.Printf("health: system_profiler failed: %v", err)
+		return device{}, false
+	}
+	for _, c := range parseCameras(string(out)) {
+		if cfg.Selector.OnUSB() {
+			if c.vendorID == cfg.Selector.VendorID && c.productID == cfg.Selector.ProductID &&
+				(cfg.Selector.Match == nil || cfg.Selector.Match.MatchString(c.name)) {
+				return device{input: c.name}, true
+			}
+		} else if strings.Contains(c.name, cfg.DeviceName) {
+			return device{input: c.name}, true
+		}
+	}
+	return device{}, false
+}
+
+// profiledCamera is one entry in `system_profiler SPCameraDataType`.
+type profiledCamera struct {
+	name                string
+	vendorID, productID uint16
+}
+
+// cameraModelRe matches a UVC camera's Model ID line, e.g.
+//
+//	Model ID: UVC Camera VendorID_4057 ProductID_123
+//
+// capturing the (decimal) vendor and product IDs. Built-in cameras have a
+// plain model name instead and don't match.
+var cameraModelRe = regexp.MustCompile(`Model ID:.*VendorID_(\d+) ProductID_(\d+)`)
+
+// parseCameras splits system_profiler's camera report into entries. Each is
+// a name line indented four spaces and ending in a colon, followed by its
+// more deeply indented properties.
+func parseCameras(out string) []profiledCamera {
+	var cams []profiledCamera
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "     ") && strings.HasSuffix(trimmed, ":") {
+			cams = append(cams, profiledCamera{name: strings.TrimSuffix(trimmed, ":")})
+			continue
+		}
+		if len(cams) == 0 {
+			continue
+		}
+		if m := cameraModelRe.FindStringSubmatch(line); m != nil {
+			vid, _ := strconv.ParseUint(m[1], 10, 16)
+			pid, _ := strconv.ParseUint(m[2], 10, 16)
+			cams[len(cams)-1].vendorID = uint16(vid)
+			cams[len(cams)-1].productID = uint16(pid)
+		}
+	}
+	return cams
+}
+
+// modeRe matches a mode line from ffmpeg's "Supported modes:" list, e.g.
+//
+//	1920x1080@[59.940180 59.940180]fps
+//
+// capturing the resolution and the (first) framerate.
+var modeRe = regexp.MustCompile(`(\d+x\d+)@\[([0-9.]+)`)
+
+// detectMode asks ffmpeg what mode the device currently advertises by
+// requesting a deliberately-invalid size (1x1). ffmpeg responds by printing
+// the device's "Supported modes:" list, which reflects the current
+// source/no-signal state. Returns the first advertised mode. ok is false if
+// the device reported no modes at all, which is itself a strong sign it's
+// wedged (a live device always answers with its capabilities).
+func (avfoundationBackend) detectMode(ctx context.Context, cfg Config, dev device) (m mode, output string, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOr(cfg, 3*time.Second))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cfg.FFmpegPath,
+		"-f", "avfoundation",
+		"-video_size", "1x1",
+		"-i", dev.input,
+		"-frames:v", "1",
+		"-f", "null", "-",
+	)
+	// This call always exits non-zero (1x1 is never valid); we want its stderr.
+	out, _ := cmd.CombinedOutput()
+	output = string(out)
+
+	sm := modeRe.FindStringSubmatch(output)
+	if sm == nil {
+		return mode{}, output, false
+	}
+	return mode{size: sm[1], framerate: sm[2]}, output, true
+}
+
+func (avfoundationBackend) inputArgs(dev device, m mode) []string {
+	return []string{
+		"-f", "avfoundation",
+		"-video_size", m.size,
+		"-framerate", m.framerate,
+		"-i", dev.input,
+	}
+}
@@ -0,0 +1,38 @@
+package health
+
+import "testing"
+
+func TestParseCameras(t *testing.T) {
+	out := `Camera:
+
+    FaceTime HD Camera:
+
+      Model ID: FaceTime HD Camera
+      Unique ID: 47B4B64B70674B9CAD2BAE273A71F4B5
+
+    Cam Link 4K:
+
+      Model ID: UVC Camera VendorID_4057 ProductID_123
+      Unique ID: 0x11000000fd9007b
+
+    Game Capture HD60 S+:
+
+      Model ID: UVC Camera VendorID_4057 ProductID_109
+      Unique ID: 0x11400000fd9006d
+
+`
+	got := parseCameras(out)
+	want := []profiledCamera{
+		{name: "FaceTime HD Camera"},
+		{name: "Cam Link 4K", vendorID: 0x0fd9, productID: 0x007b},
+		{name: "Game Capture HD60 S+", vendorID: 0x0fd9, productID: 0x006d},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d cameras, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("camera %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Backend selects how a camera is located and opened for health checks.
+type Backend string
+
+const (
+	// AVFoundation finds devices via system_profiler and opens them with
+	// ffmpeg's avfoundation input (macOS).
+	AVFoundation Backend = "avfoundation"
+	// V4L2 finds devices under /sys/class/video4linux and opens the
+	// /dev/videoN node with ffmpeg's v4l2 input (Linux).
+	V4L2 Backend = "v4l2"
+)
+
+// DefaultBackend returns the backend native to the running OS.
+func DefaultBackend() Backend {
+	if runtime.GOOS == "linux" {
+		return V4L2
+	}
+	return AVFoundation
+}
+
+// mode is a capture mode as advertised by the device. framerate and
+// pixelFormat are optional: avfoundation reports a rate but no format, v4l2
+// the other way round.
+type mode struct {
+	size        string
+	framerate   string
+	pixelFormat string
+}
+
+func (m mode) String() string {
+	s := m.size
+	if m.framerate != "" {
+		s += "@" + m.framerate
+	}
+	if m.pixelFormat != "" {
+		s += " " + m.pixelFormat
+	}
+	return s
+}
+
+// matches reports whether m is the mode described by spec ("WxH" or
+// "WxH@rate"). The rate is compared as a prefix, since devices report
+// fractional rates like 30.000030, and only when both sides have one.
+func (m mode) matches(spec string) bool {
+	size, rate, _ := strings.Cut(spec, "@")
+	if size != m.size {
+		return false
+	}
+	if rate == "" || m.framerate == "" {
+		return true
+	}
+	return strings.HasPrefix(m.framerate, rate)
+}
+
+// device is a located camera.
+type device struct {
+	// input is what to pass to ffmpeg's -i.
+	input string
+	// usbPath is the sysfs name of the USB device the camera hangs off
+	// (e.g. "4-1.2"), when the backend can tell.
+	usbPath string
+}
+
+func (d device) String() string {
+	if d.usbPath != "" {
+		return d.input + " on usb " + d.usbPath
+	}
+	return d.input
+}
+
+// backend is the platform-specific half of a health check: finding the
+// device, asking it what mode it advertises, and building ffmpeg input args.
+type backend interface {
+	find(cfg Config) (device, bool)
+	detectMode(ctx context.Context, cfg Config, dev device) (m mode, output string, ok bool)
+	inputArgs(dev device, m mode) []string
+}
+
+// ParseBackend validates a backend name, as given on the command line. An
+// empty name selects DefaultBackend().
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(s); b {
+	case "":
+		return DefaultBackend(), nil
+	case AVFoundation, V4L2:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown capture backend %q (want %s or %s)", s, AVFoundation, V4L2)
+	}
+}
+
+// effectiveBackend resolves an empty Backend to the platform default.
+func (cfg Config) effectiveBackend() Backend {
+	if cfg.Backend == "" {
+		return DefaultBackend()
+	}
+	return cfg.Backend
+}
+
+func (cfg Config) backend() backend {
+	if cfg.effectiveBackend() == V4L2 {
+		return v4l2Backend{}
+	}
+	return avfoundationBackend{}
+}
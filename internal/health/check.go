@@ -4,25 +4,45 @@ import (
 	"context"
 	"log"
 	"os/exec"
-	"regexp"
 	"strings"
 	"time"
+
+	usbdevice "github.com/phinze/camlink-fix/internal/device"
 )
 
 // Config holds paths and parameters for camera health checks.
 type Config struct {
 	FFmpegPath string
+	// DeviceName is the camera's name for logs, and what's used to find it
+	// when Selector is unset.
 	DeviceName string
-	Timeout    time.Duration
+	// Selector identifies the camera by USB identity. It takes precedence
+	// over DeviceName wherever the backend can see USB IDs.
+	Selector usbdevice.Selector
+	Timeout  time.Duration
+
+	// Backend selects how the device is found and opened. Empty means
+	// DefaultBackend().
+	Backend Backend
+	// NoSignalMode is the mode the device falls back to with no HDMI input,
+	// as "WxH" or "WxH@rate" (e.g. "3840x2160@30" for a Cam Link 4K). A
+	// frame at this mode is reported as StateNoSignal rather than
+	// StateHealthy. Empty disables the distinction.
+	NoSignalMode string
+	// SysfsRoot is where the v4l2 backend looks for video4linux devices.
+	// Empty means /sys.
+	SysfsRoot string
 }
 
-// Listed returns true if the camera appears in system_profiler output.
+// Listed returns true if the camera is present: in system_profiler output on
+// avfoundation, under /sys/class/video4linux on v4l2.
 func Listed(cfg Config) bool {
-	return isListed(cfg.DeviceName)
+	_, ok := cfg.backend().find(cfg)
+	return ok
 }
 
-// Check returns true if the camera is detected and can produce a frame at its
-// currently-advertised mode.
+// Check reports whether the camera is detected and can produce a frame at
+// its currently-advertised mode.
 //
 // The subtle part: a Cam Link advertises a *different* capture mode depending
 // on whether an HDMI source is present (e.g. 1920x1080@59.94 locked to a
@@ -36,91 +56,91 @@ func Listed(cfg Config) bool {
 // healthy camera the instant the mode isn't exactly that), we ask the device
 // what it's offering and grab a frame at that. This is what lets us tell
 // "wedged, reset it" apart from "idle with no signal, leave it alone".
-func Check(cfg Config) bool {
-	if !isListed(cfg.DeviceName) {
-		log.Printf("health: %q not found in system_profiler", cfg.DeviceName)
-		return false
+//
+// Cancelling ctx kills any running ffmpeg; the result is then StateUnknown
+// rather than a failure, since the camera never got a fair chance to answer.
+func Check(ctx context.Context, cfg Config) Result {
+	b := cfg.backend()
+	dev, ok := b.find(cfg)
+	if !ok {
+		log.Printf("health: %q (%s) not found (%s)", cfg.DeviceName, cfg.Selector, cfg.effectiveBackend())
+		return Result{State: StateAbsent, Reason: "not enumerated"}
 	}
 
-	return canCapture(cfg)
-}
-
-// isListed checks whether the device appears in system_profiler output.
-func isListed(deviceName string) bool {
-	out, err := exec.Command("system_profiler", "SPCameraDataType").Output()
-	if err != nil {
-		log.Printf("health: system_profiler failed: %v", err)
-		return false
-	}
-	return strings.Contains(string(out), deviceName)
+	return capture(ctx, cfg, b, dev)
 }
 
-// modeRe matches a mode line from ffmpeg's "Supported modes:" list, e.g.
-//
-//	1920x1080@[59.940180 59.940180]fps
-//
-// capturing the resolution and the (first) framerate.
-var modeRe = regexp.MustCompile(`(\d+x\d+)@\[([0-9.]+)`)
-
-// detectMode asks ffmpeg what mode the device currently advertises by
-// requesting a deliberately-invalid size (1x1). ffmpeg responds by printing
-// the device's "Supported modes:" list, which reflects the current
-// source/no-signal state. Returns the first advertised mode. ok is false if
-// the device reported no modes at all, which is itself a strong sign it's
-// wedged (a live device always answers with its capabilities).
-func detectMode(cfg Config) (size, framerate, output string, ok bool) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutOr(cfg, 3*time.Second))
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, cfg.FFmpegPath,
-		"-f", "avfoundation",
-		"-video_size", "1x1",
-		"-i", cfg.DeviceName,
-		"-frames:v", "1",
-		"-f", "null", "-",
-	)
-	// This call always exits non-zero (1x1 is never valid); we want its stderr.
-	out, _ := cmd.CombinedOutput()
-	output = string(out)
-
-	m := modeRe.FindStringSubmatch(output)
-	if m == nil {
-		return "", "", output, false
-	}
-	return m[1], m[2], output, true
-}
+// cancelled is the result of a check cut short by its caller.
+var cancelled = Result{State: StateUnknown, Reason: "check cancelled"}
 
-// canCapture detects the device's advertised mode and tries to grab a single
+// capture detects the device's advertised mode and tries to grab a single
 // frame at it. A healthy device delivers one near-instantly (tens of ms); a
-// wedged device does not. ffmpeg's stderr is logged on any failure so we can
-// build up a library of real-world wedge signatures.
-func canCapture(cfg Config) bool {
-	size, framerate, detectOut, ok := detectMode(cfg)
+// wedged device does not. Failures are classified into a Signature and
+// ffmpeg's stderr is logged alongside it; tails that come out as SigUnknown
+// belong in testdata/signatures once we know what they mean.
+func capture(ctx context.Context, cfg Config, b backend, dev device) Result {
+	m, detectOut, ok := b.detectMode(ctx, cfg, dev)
+	if ctx.Err() != nil {
+		return cancelled
+	}
 	if !ok {
-		log.Printf("health: %q advertised no modes (likely wedged); ffmpeg said:\n%s",
-			cfg.DeviceName, lastLines(detectOut, 12))
-		return false
+		res := failure(PhaseDetect, detectOut, false)
+		log.Printf("health: %q (%s) mode probe failed [%s]; ffmpeg said:\n%s",
+			cfg.DeviceName, dev, res.Signature, res.ErrTail)
+		return res
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutOr(cfg, 3*time.Second))
+	timeout := timeoutOr(cfg, 3*time.Second)
+	captureCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, cfg.FFmpegPath,
-		"-f", "avfoundation",
-		"-video_size", size,
-		"-framerate", framerate,
-		"-i", cfg.DeviceName,
+	args := append(b.inputArgs(dev, m),
 		"-frames:v", "1",
 		"-f", "null", "-",
 	)
+	cmd := exec.CommandContext(captureCtx, cfg.FFmpegPath, args...)
 
+	start := time.Now()
 	out, err := cmd.CombinedOutput()
+	elapsed := time.Since(start)
+
+	if ctx.Err() != nil {
+		return cancelled
+	}
 	if err != nil {
-		log.Printf("health: %q failed frame capture at %s@%s; ffmpeg said:\n%s",
-			cfg.DeviceName, size, framerate, lastLines(string(out), 12))
-		return false
+		res := failure(PhaseCapture, string(out), captureCtx.Err() == context.DeadlineExceeded)
+		res.Mode = m.String()
+		log.Printf("health: %q (%s) failed frame capture at %s [%s]; ffmpeg said:\n%s",
+			cfg.DeviceName, dev, m, res.Signature, res.ErrTail)
+		return res
+	}
+
+	res := Result{State: StateHealthy, Mode: m.String(), FirstFrame: elapsed}
+	if cfg.NoSignalMode != "" && m.matches(cfg.NoSignalMode) {
+		res.State = StateNoSignal
+	}
+	return res
+}
+
+// failure classifies a failed ffmpeg run into a Result.
+func failure(phase Phase, ffmpegOut string, timedOut bool) Result {
+	sig := Classify(phase, ffmpegOut, timedOut)
+	res := Result{
+		ErrTail:   lastLines(ffmpegOut, 12),
+		Signature: sig,
+		Reason:    sig.describe(),
+	}
+	switch sig {
+	case SigBusy:
+		res.State = StateBusy
+	case SigNoModes:
+		res.State = StateWedged
+	case SigTimeout:
+		res.State = StateTimeout
+	default:
+		res.State = StateFailed
 	}
-	return true
+	return res
 }
 
 func timeoutOr(cfg Config, def time.Duration) time.Duration {
@@ -75,3 +75,22 @@ func TestLastLines(t *testing.T) {
 		t.Errorf("lastLines short = %q", got)
 	}
 }
+
+func TestModeMatchesNoSignalSpec(t *testing.T) {
+	tests := []struct {
+		mode mode
+		spec string
+		want bool
+	}{
+		{mode{size: "3840x2160", framerate: "30.000030"}, "3840x2160@30", true},
+		{mode{size: "3840x2160", framerate: "29.970030"}, "3840x2160@30", false},
+		{mode{size: "1920x1080", framerate: "59.940180"}, "3840x2160@30", false},
+		{mode{size: "3840x2160", pixelFormat: "nv12"}, "3840x2160@30", true},
+		{mode{size: "3840x2160", framerate: "30.000030"}, "3840x2160", true},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.matches(tt.spec); got != tt.want {
+			t.Errorf("%s matches %q = %v, want %v", tt.mode, tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is the outcome of a health check.
+type State int
+
+const (
+	// StateUnknown is the zero value: no check has run.
+	StateUnknown State = iota
+	// StateHealthy means the device produced a frame at its advertised mode.
+	StateHealthy
+	// StateNoSignal means the device produced a frame at its no-signal mode:
+	// healthy, just nothing plugged into the HDMI side.
+	StateNoSignal
+	// StateAbsent means the device isn't enumerated at all.
+	StateAbsent
+	// StateWedged means the device is present but advertised no modes.
+	StateWedged
+	// StateTimeout means the device advertised a mode but no frame arrived
+	// before the deadline.
+	StateTimeout
+	// StateBusy means another process holds the device open exclusively.
+	StateBusy
+	// StateFailed means frame capture failed for some other reason.
+	StateFailed
+)
+
+var stateNames = map[State]string{
+	StateUnknown:  "unknown",
+	StateHealthy:  "healthy",
+	StateNoSignal: "no-signal",
+	StateAbsent:   "absent",
+	StateWedged:   "wedged",
+	StateTimeout:  "timeout",
+	StateBusy:     "busy",
+	StateFailed:   "failed",
+}
+
+func (s State) String() string {
+	if n, ok := stateNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// MarshalText encodes a State by name, so JSON carries "wedged" rather than 4.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a State name produced by MarshalText.
+func (s *State) UnmarshalText(b []byte) error {
+	for st, n := range stateNames {
+		if n == string(b) {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown health state %q", b)
+}
+
+// Result is what a health check found.
+type Result struct {
+	State State `json:"state"`
+	// Mode is the capture mode the device advertised, e.g.
+	// "1920x1080@59.940180". Empty if it advertised none.
+	Mode string `json:"mode,omitempty"`
+	// FirstFrame is how long the capture took to deliver its first frame,
+	// including ffmpeg startup. Zero unless a frame arrived.
+	FirstFrame time.Duration `json:"first_frame,omitempty"`
+	// ErrTail is the last lines of ffmpeg's stderr on failure.
+	ErrTail string `json:"err_tail,omitempty"`
+	// Signature classifies ErrTail. SigNone when healthy or absent.
+	Signature Signature `json:"signature,omitempty"`
+	// Reason says in a few words why the check failed. Empty when healthy.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Healthy reports whether the device is working, with or without a signal.
+func (r Result) Healthy() bool {
+	return r.State == StateHealthy || r.State == StateNoSignal
+}
+
+// NeedsReset reports whether the result is a failure a power cycle might fix.
+// An absent device has nothing to reset, and see Signature.Resettable for
+// the failures that aren't the device's fault.
+func (r Result) NeedsReset() bool {
+	return r.Signature.Resettable()
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Healthy():
+		return fmt.Sprintf("%s at %s (first frame %s)", r.State, r.Mode, r.FirstFrame.Round(time.Millisecond))
+	case r.Signature != SigNone:
+		return fmt.Sprintf("%s [%s] (%s)", r.State, r.Signature, r.Reason)
+	case r.Reason != "":
+		return fmt.Sprintf("%s (%s)", r.State, r.Reason)
+	default:
+		return r.State.String()
+	}
+}
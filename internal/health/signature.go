@@ -0,0 +1,139 @@
+package health
+
+import "strings"
+
+// Signature names a recognisable way ffmpeg fails against a sick (or merely
+// unavailable) camera. Signatures are stable strings so logs, notifications
+// and history can be grepped for which failure the hardware actually hits.
+type Signature string
+
+const (
+	// SigNone means there was nothing to classify (healthy, or absent).
+	SigNone Signature = ""
+	// SigNoModes: the mode probe got no answer — the classic wedge.
+	SigNoModes Signature = "no-modes"
+	// SigIOErrorOnOpen: the device advertised a mode, then failed with an
+	// I/O error when opened for capture.
+	SigIOErrorOnOpen Signature = "io-error-on-open"
+	// SigTimeout: the capture opened but no frame arrived before the deadline.
+	SigTimeout Signature = "timeout-no-frames"
+	// SigBusy: another process is streaming from the device.
+	SigBusy Signature = "device-busy"
+	// SigPermissionDenied: we aren't allowed to open the device (video group
+	// on Linux, camera privacy consent on macOS).
+	SigPermissionDenied Signature = "permission-denied"
+	// SigFormatNegotiation: the device refused the mode it had just
+	// advertised, or switched to a different one mid-open.
+	SigFormatNegotiation Signature = "format-negotiation"
+	// SigUnknown: failed in a way we don't recognise yet. These are the
+	// stderr tails worth capturing into testdata/signatures.
+	SigUnknown Signature = "unknown"
+)
+
+// Signatures lists every signature Classify can return.
+var Signatures = []Signature{
+	SigPermissionDenied,
+	SigBusy,
+	SigNoModes,
+	SigTimeout,
+	SigFormatNegotiation,
+	SigIOErrorOnOpen,
+	SigUnknown,
+}
+
+// Resettable reports whether a power cycle could plausibly fix a device
+// failing this way. Busy and permission problems aren't the device's fault,
+// and resetting a busy camera would kill someone's live stream.
+func (s Signature) Resettable() bool {
+	switch s {
+	case SigNone, SigBusy, SigPermissionDenied:
+		return false
+	}
+	return true
+}
+
+// describe is the few-words explanation used as Result.Reason.
+func (s Signature) describe() string {
+	switch s {
+	case SigNoModes:
+		return "advertised no modes"
+	case SigIOErrorOnOpen:
+		return "I/O error opening device"
+	case SigTimeout:
+		return "no frame before timeout"
+	case SigBusy:
+		return "device busy"
+	case SigPermissionDenied:
+		return "permission denied"
+	case SigFormatNegotiation:
+		return "refused its advertised mode"
+	case SigUnknown:
+		return "capture failed"
+	}
+	return ""
+}
+
+// Phase is which of the two ffmpeg runs in a health check failed.
+type Phase int
+
+const (
+	// PhaseDetect is the mode probe (avfoundation 1x1, v4l2 -list_formats).
+	PhaseDetect Phase = iota
+	// PhaseCapture is the single-frame grab at the advertised mode.
+	PhaseCapture
+)
+
+var (
+	permissionMarkers = []string{
+		"Permission denied",
+		"Operation not permitted",
+	}
+	busyMarkers = []string{
+		"Device or resource busy",
+	}
+	formatMarkers = []string{
+		"is not supported by the device", // avfoundation: size/framerate/pixel format
+		"The V4L2 driver changed the",
+		"Cannot find a proper format",
+		"VIDIOC_S_FMT",
+	}
+	ioErrorMarkers = []string{
+		"Input/output error",
+	}
+)
+
+// Classify maps the output of a failed ffmpeg run to a signature. timedOut
+// says whether we killed ffmpeg at the deadline, which is not visible in its
+// output: a capture that never delivers a frame just sits there silently.
+//
+// Phase matters because the detect probe fails on purpose: avfoundation's
+// 1x1 request always ends in "not supported by the device" and an I/O
+// error, even on a healthy camera. A detect-phase failure that isn't busy or
+// permissions is therefore always "no modes" — the probe only fails at all
+// when it found nothing to parse.
+func Classify(phase Phase, ffmpegOut string, timedOut bool) Signature {
+	switch {
+	case containsAny(ffmpegOut, permissionMarkers):
+		return SigPermissionDenied
+	case containsAny(ffmpegOut, busyMarkers):
+		return SigBusy
+	case phase == PhaseDetect:
+		return SigNoModes
+	case timedOut:
+		return SigTimeout
+	case containsAny(ffmpegOut, formatMarkers):
+		return SigFormatNegotiation
+	case containsAny(ffmpegOut, ioErrorMarkers):
+		return SigIOErrorOnOpen
+	}
+	return SigUnknown
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, m := range markers {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
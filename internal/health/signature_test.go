@@ -0,0 +1,84 @@
+package health
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestClassifyFixtures runs every captured stderr tail in testdata/signatures
+// through Classify and expects the signature named by its directory.
+func TestClassifyFixtures(t *testing.T) {
+	dirs, err := os.ReadDir(filepath.Join("testdata", "signatures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[Signature]bool{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		want := Signature(dir.Name())
+		if !slices.Contains(Signatures, want) {
+			t.Errorf("testdata/signatures/%s is not a known signature", dir.Name())
+			continue
+		}
+
+		files, err := filepath.Glob(filepath.Join("testdata", "signatures", dir.Name(), "*.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			name := filepath.Base(file)
+			t.Run(dir.Name()+"/"+name, func(t *testing.T) {
+				phase, timedOut := fixturePhase(t, name)
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := Classify(phase, string(data), timedOut); got != want {
+					t.Errorf("Classify = %q, want %q", got, want)
+				}
+			})
+			seen[want] = true
+		}
+	}
+
+	for _, sig := range Signatures {
+		if !seen[sig] {
+			t.Errorf("no fixtures for signature %q", sig)
+		}
+	}
+}
+
+// fixturePhase decodes the phase and timeout flag from a fixture file name:
+// "detect-...", "capture-..." or "capture-timeout-...".
+func fixturePhase(t *testing.T, name string) (Phase, bool) {
+	t.Helper()
+	switch {
+	case strings.HasPrefix(name, "detect-"):
+		return PhaseDetect, false
+	case strings.HasPrefix(name, "capture-timeout-"):
+		return PhaseCapture, true
+	case strings.HasPrefix(name, "capture-"):
+		return PhaseCapture, false
+	}
+	t.Fatalf("fixture %s doesn't start with detect- or capture-", name)
+	return 0, false
+}
+
+func TestSignatureResettable(t *testing.T) {
+	for _, sig := range []Signature{SigNoModes, SigIOErrorOnOpen, SigTimeout, SigFormatNegotiation, SigUnknown} {
+		if !sig.Resettable() {
+			t.Errorf("%s should be resettable", sig)
+		}
+	}
+	for _, sig := range []Signature{SigNone, SigBusy, SigPermissionDenied} {
+		if sig.Resettable() {
+			t.Errorf("%q should not be resettable", sig)
+		}
+	}
+}
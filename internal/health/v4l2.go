@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	usbdevice "github.com/phinze/camlink-fix/internal/device"
+)
+
+// v4l2Backend locates cameras under /sys/class/video4linux and opens the
+// matching /dev/videoN node through ffmpeg's v4l2 input.
+type v4l2Backend struct{}
+
+// find scans video4linux for a capture node belonging to the selected USB
+// device, or, without a selector, whose name contains the configured device
+// name. UVC devices register a second node with the same name for metadata;
+// only index 0 is the one that delivers frames.
+func (v4l2Backend) find(cfg Config) (device, bool) {
+	root := cfg.SysfsRoot
+	if root == "" {
+		root = "/sys"
+	}
+
+	nodes, err := filepath.Glob(filepath.Join(root, "class", "video4linux", "video*"))
+	if err != nil {
+		return device{}, false
+	}
+	for _, node := range nodes {
+		if idx := readAttr(node, "index"); idx != "" && idx != "0" {
+			continue
+		}
+		usbDev := usbParent(node)
+		if cfg.Selector.OnUSB() {
+			if usbDev == "" || !selects(cfg.Selector, usbDev) {
+				continue
+			}
+		} else if !strings.Contains(readAttr(node, "name"), cfg.DeviceName) {
+			continue
+		}
+
+		dev := device{input: "/dev/" + filepath.Base(node)}
+		if usbDev != "" {
+			dev.usbPath = filepath.Base(usbDev)
+		}
+		return dev, true
+	}
+	return device{}, false
+}
+
+// selects reports whether the USB device at sysfs directory dir is the one
+// sel picks out.
+func selects(sel usbdevice.Selector, dir string) bool {
+	vid, err1 := strconv.ParseUint(readAttr(dir, "idVendor"), 16, 16)
+	pid, err2 := strconv.ParseUint(readAttr(dir, "idProduct"), 16, 16)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	desc := strings.TrimSpace(readAttr(dir, "manufacturer") + " " + readAttr(dir, "product"))
+	return sel.Matches(uint16(vid), uint16(pid), readAttr(dir, "serial"), desc)
+}
+
+// usbParent resolves a video4linux node to the sysfs directory of the USB
+// device it belongs to. The node's "device" link points at the UVC interface
+// (e.g. .../4-1.2/4-1.2:1.0); its parent directory is the USB device itself,
+// identified by having an idVendor attribute.
+func usbParent(node string) string {
+	iface, err := filepath.EvalSymlinks(filepath.Join(node, "device"))
+	if err != nil {
+		return ""
+	}
+	dev := filepath.Dir(iface)
+	if _, err := os.Stat(filepath.Join(dev, "idVendor")); err != nil {
+		return ""
+	}
+	return dev
+}
+
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// v4l2ModeRe matches a format line from `ffmpeg -f v4l2 -list_formats all`,
+// e.g.
+//
+//	[video4linux2,v4l2 @ 0x55d5c8f0e6c0] Raw       :     yuyv422 :           YUYV 4:2:2 : 3840x2160
+//
+// capturing the ffmpeg pixel format and the first listed frame size. The
+// description column can itself contain colons ("YUYV 4:2:2"), so the size
+// is anchored on the last one.
+var v4l2ModeRe = regexp.MustCompile(`(?:Raw|Compressed)\s*:\s*(\S+)\s*:.*:\s*(\d+x\d+)`)
+
+// detectMode lists the formats the device advertises. Like the avfoundation
+// 1x1 probe, the advertised size follows the HDMI source (or the no-signal
+// default), and a wedged device lists nothing at all. v4l2 doesn't report
+// framerates here, so capture runs at the driver's default rate.
+func (v4l2Backend) detectMode(ctx context.Context, cfg Config, dev device) (m mode, output string, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOr(cfg, 3*time.Second))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cfg.FFmpegPath,
+		"-f", "v4l2",
+		"-list_formats", "all",
+		"-i", dev.input,
+	)
+	// -list_formats exits non-zero after printing ("Immediate exit requested").
+	out, _ := cmd.CombinedOutput()
+	output = string(out)
+
+	sm := v4l2ModeRe.FindStringSubmatch(output)
+	if sm == nil {
+		return mode{}, output, false
+	}
+	return mode{size: sm[2], pixelFormat: sm[1]}, output, true
+}
+
+func (v4l2Backend) inputArgs(dev device, m mode) []string {
+	args := []string{"-f", "v4l2"}
+	if m.pixelFormat != "" {
+		args = append(args, "-input_format", m.pixelFormat)
+	}
+	return append(args,
+		"-video_size", m.size,
+		"-i", dev.input,
+	)
+}
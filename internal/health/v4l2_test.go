@@ -0,0 +1,174 @@
+package health
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	usbdevice "github.com/phinze/camlink-fix/internal/device"
+)
+
+func TestV4L2ModeReParsesAdvertisedModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		ffmpegStderr string
+		wantFormat   string
+		wantSize     string
+		wantMatch    bool
+	}{
+		{
+			name: "1080p60 with a live source",
+			ffmpegStderr: `[video4linux2,v4l2 @ 0x55d5c8f0e6c0] Raw       :     yuyv422 :           YUYV 4:2:2 : 1920x1080
+[video4linux2,v4l2 @ 0x55d5c8f0e6c0] Raw       :        nv12 :       Y/CbCr 4:2:0 : 1920x1080
+/dev/video0: Immediate exit requested`,
+			wantFormat: "yuyv422",
+			wantSize:   "1920x1080",
+			wantMatch:  true,
+		},
+		{
+			name: "4K30 no-signal / default mode",
+			ffmpegStderr: `[video4linux2,v4l2 @ 0x5612a4b1c2c0] Raw       :        nv12 :       Y/CbCr 4:2:0 : 3840x2160
+[video4linux2,v4l2 @ 0x5612a4b1c2c0] Raw       :     yuyv422 :           YUYV 4:2:2 : 3840x2160
+/dev/video0: Immediate exit requested`,
+			wantFormat: "nv12",
+			wantSize:   "3840x2160",
+			wantMatch:  true,
+		},
+		{
+			name: "picks the first size when several are listed",
+			ffmpegStderr: `[video4linux2,v4l2 @ 0x5581] Compressed:       mjpeg :          Motion-JPEG : 1280x720 640x480 320x240
+[video4linux2,v4l2 @ 0x5581] Raw       :     yuyv422 :           YUYV 4:2:2 : 640x480 320x240`,
+			wantFormat: "mjpeg",
+			wantSize:   "1280x720",
+			wantMatch:  true,
+		},
+		{
+			name: "open fails (wedged) yields no match",
+			ffmpegStderr: `[video4linux2,v4l2 @ 0x5581] Cannot open video device /dev/video0: Input/output error
+/dev/video0: Input/output error`,
+			wantMatch: false,
+		},
+		{
+			name:         "device lists no formats yields no match",
+			ffmpegStderr: `/dev/video0: Immediate exit requested`,
+			wantMatch:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := v4l2ModeRe.FindStringSubmatch(tt.ffmpegStderr)
+			if tt.wantMatch != (m != nil) {
+				t.Fatalf("match = %v, want %v", m != nil, tt.wantMatch)
+			}
+			if !tt.wantMatch {
+				return
+			}
+			if m[1] != tt.wantFormat {
+				t.Errorf("pixel format = %q, want %q", m[1], tt.wantFormat)
+			}
+			if m[2] != tt.wantSize {
+				t.Errorf("size = %q, want %q", m[2], tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestV4L2FindsCaptureNode(t *testing.T) {
+	root := t.TempDir()
+
+	// A Cam Link registers a capture node and a metadata node under the same
+	// name, both pointing at its UVC interface.
+	usbDev := filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb4", "4-1", "4-1.2")
+	iface := filepath.Join(usbDev, "4-1.2:1.0")
+	mustWrite(t, filepath.Join(usbDev, "idVendor"), "0fd9")
+	if err := os.MkdirAll(iface, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	v4l := filepath.Join(root, "class", "video4linux")
+	addNode := func(node, name, index, target string) {
+		t.Helper()
+		mustWrite(t, filepath.Join(v4l, node, "name"), name)
+		mustWrite(t, filepath.Join(v4l, node, "index"), index)
+		if err := os.Symlink(target, filepath.Join(v4l, node, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addNode("video0", "Integrated Camera: Integrated C", "0", t.TempDir())
+	addNode("video2", "Cam Link 4K: Cam Link 4K", "1", iface)
+	addNode("video3", "Cam Link 4K: Cam Link 4K", "0", iface)
+
+	cfg := Config{DeviceName: "Cam Link 4K", SysfsRoot: root}
+	dev, ok := v4l2Backend{}.find(cfg)
+	if !ok {
+		t.Fatal("Cam Link not found")
+	}
+	if dev.input != "/dev/video3" {
+		t.Errorf("input = %q, want /dev/video3", dev.input)
+	}
+	if dev.usbPath != "4-1.2" {
+		t.Errorf("usbPath = %q, want 4-1.2", dev.usbPath)
+	}
+
+	cfg.DeviceName = "HD60 S+"
+	if _, ok := (v4l2Backend{}).find(cfg); ok {
+		t.Error("found a device that isn't there")
+	}
+}
+
+func TestV4L2FindsBySelector(t *testing.T) {
+	root := t.TempDir()
+	v4l := filepath.Join(root, "class", "video4linux")
+
+	// Two Cam Links with identical names; only their USB serials differ.
+	addCamLink := func(usbPath, node, serial string) {
+		t.Helper()
+		usbDev := filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb4", "4-1", usbPath)
+		iface := filepath.Join(usbDev, usbPath+":1.0")
+		mustWrite(t, filepath.Join(usbDev, "idVendor"), "0fd9")
+		mustWrite(t, filepath.Join(usbDev, "idProduct"), "007b")
+		mustWrite(t, filepath.Join(usbDev, "manufacturer"), "Elgato")
+		mustWrite(t, filepath.Join(usbDev, "product"), "Cam Link 4K")
+		mustWrite(t, filepath.Join(usbDev, "serial"), serial)
+		if err := os.MkdirAll(iface, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		mustWrite(t, filepath.Join(v4l, node, "name"), "Cam Link 4K: Cam Link 4K")
+		mustWrite(t, filepath.Join(v4l, node, "index"), "0")
+		if err := os.Symlink(iface, filepath.Join(v4l, node, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addCamLink("4-1.1", "video0", "0005AAAA0001")
+	addCamLink("4-1.2", "video2", "0005BBBB0002")
+
+	sel, err := usbdevice.New("0fd9:007b", "0005BBBB0002", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The name would match either camera; the selector must win.
+	cfg := Config{DeviceName: "Cam Link 4K", Selector: sel, SysfsRoot: root}
+	dev, ok := v4l2Backend{}.find(cfg)
+	if !ok {
+		t.Fatal("Cam Link not found")
+	}
+	if dev.input != "/dev/video2" || dev.usbPath != "4-1.2" {
+		t.Errorf("found %s, want /dev/video2 on usb 4-1.2", dev)
+	}
+
+	cfg.Selector, _ = usbdevice.New("0fd9:006d", "", "")
+	if _, ok := (v4l2Backend{}).find(cfg); ok {
+		t.Error("found an HD60 S+ that isn't there")
+	}
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package history keeps a record of every check/reset cycle the daemon
+// runs, one JSON object per line, so incidents can be looked back on: how
+// often the camera wedges, which trigger catches it, which stage fixes it.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+// Outcome is how a cycle ended.
+type Outcome string
+
+const (
+	// OutcomeHealthy: the first check passed.
+	OutcomeHealthy Outcome = "healthy"
+	// OutcomeLeftAlone: the check failed in a way a reset doesn't fix
+	// (absent, busy, permissions), so none was tried.
+	OutcomeLeftAlone Outcome = "left-alone"
+	// OutcomeUnresettable: a reset was called for but couldn't start
+	// (the device isn't on USB, or couldn't be found in the hub tree), or
+	// no stage could act on it.
+	OutcomeUnresettable Outcome = "unresettable"
+	// OutcomeHeldOff: a reset was called for, but the circuit breaker had
+	// tripped, so none was tried.
+	OutcomeHeldOff Outcome = "held-off"
+	// OutcomeFixed: a reset stage brought the device back.
+	OutcomeFixed Outcome = "fixed"
+	// OutcomeFailed: every stage ran and the device is still broken.
+	OutcomeFailed Outcome = "failed"
+	// OutcomeInterrupted: the daemon shut down mid-cycle.
+	OutcomeInterrupted Outcome = "interrupted"
+)
+
+// Record is one check/reset cycle.
+type Record struct {
+	// Time is when the cycle started.
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	// Trigger is what prompted the cycle: the batch of events ("wake",
+	// "wake+usb-arrival"), or a ctl command.
+	Trigger string `json:"trigger"`
+	// Retry is the retry attempt, 0 for the cycle a trigger started.
+	Retry int `json:"retry,omitempty"`
+	// Check is the health check that opened the cycle.
+	Check health.Result `json:"check"`
+	// Reset is what the reset ladder did, if one ran.
+	Reset *reset.Report `json:"reset,omitempty"`
+	// HeldOff is set when a reset was called for but the circuit breaker
+	// held it off.
+	HeldOff bool `json:"held_off,omitempty"`
+	// Result is the health at the end of the cycle.
+	Result   health.Result `json:"result"`
+	Outcome  Outcome       `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Incident reports whether r opened an incident: a cycle a trigger started
+// that found the device wedged. Its retries belong to the same incident.
+func (r Record) Incident() bool {
+	return r.Retry == 0 && r.Check.NeedsReset()
+}
+
+// Failure names how the opening check failed: its signature, or its state
+// when there's no ffmpeg output to classify.
+func (r Record) Failure() string {
+	if r.Check.Signature != health.SigNone {
+		return string(r.Check.Signature)
+	}
+	return r.Check.State.String()
+}
+
+// Classify works out a cycle's outcome from its opening check, the reset
+// report if a reset ran, the final health, and whether it was cut short or
+// held off by the circuit breaker.
+func Classify(check health.Result, rep *reset.Report, res health.Result, heldOff, interrupted bool) Outcome {
+	switch {
+	case interrupted:
+		return OutcomeInterrupted
+	case check.Healthy():
+		return OutcomeHealthy
+	case !check.NeedsReset():
+		return OutcomeLeftAlone
+	case heldOff && rep == nil:
+		return OutcomeHeldOff
+	case rep == nil || res.State == health.StateUnknown:
+		return OutcomeUnresettable
+	case rep.FixedBy != "":
+		return OutcomeFixed
+	default:
+		return OutcomeFailed
+	}
+}
+
+// DefaultPath is history.jsonl in camlink-fix's XDG state directory,
+// $XDG_STATE_HOME/camlink-fix or ~/.local/state/camlink-fix, next to the
+// reset journal.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "camlink-fix", "history.jsonl")
+}
+
+// Append adds r to the history file at path, creating it if need be. Each
+// record is a single write of a single line, so concurrent cycles don't
+// interleave.
+func Append(path string, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads every record in the history file at path, oldest first. A
+// missing file is an empty history. A line that doesn't parse (a write cut
+// off by a crash) is skipped and counted in skipped.
+func Load(path string) (records []Record, skipped int, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			skipped++
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := sc.Err(); err != nil {
+		return records, skipped, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return records, skipped, nil
+}
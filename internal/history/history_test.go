@@ -0,0 +1,123 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+var (
+	healthy = health.Result{State: health.StateHealthy}
+	wedged  = health.Result{State: health.StateWedged, Signature: health.SigNoModes}
+	timeout = health.Result{State: health.StateTimeout, Signature: health.SigTimeout}
+)
+
+func fixedBy(stage string, tried ...string) *reset.Report {
+	rep := &reset.Report{FixedBy: stage}
+	for _, s := range tried {
+		rep.Stages = append(rep.Stages, reset.StageOutcome{Stage: s})
+	}
+	return rep
+}
+
+func TestAppendLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "camlink-fix", "history.jsonl")
+	at := time.Date(2026, 10, 14, 9, 12, 0, 0, time.UTC)
+	want := []Record{
+		{Time: at, Device: "desk", Trigger: "wake", Check: healthy, Result: healthy, Outcome: OutcomeHealthy},
+		{Time: at.Add(time.Hour), Device: "desk", Trigger: "usb-arrival", Check: wedged,
+			Reset: fixedBy("quick cycle", "quick cycle"), Result: healthy, Outcome: OutcomeFixed, Duration: 6 * time.Second},
+	}
+	for _, r := range want {
+		if err := Append(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A crash mid-write leaves half a line.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"time":"2026-10-14T11:00:00Z","dev`)
+	f.Close()
+
+	got, skipped, err := Load(path)
+	if err != nil || skipped != 1 {
+		t.Fatalf("Load: %d skipped, %v; want 1 skipped", skipped, err)
+	}
+	if len(got) != 2 || !got[1].Time.Equal(want[1].Time) || got[1].Check.State != health.StateWedged ||
+		got[1].Reset == nil || got[1].Reset.FixedBy != "quick cycle" || got[1].Outcome != OutcomeFixed {
+		t.Errorf("Load = %+v", got)
+	}
+
+	if none, _, err := Load(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || none != nil {
+		t.Errorf("Load(missing) = %v, %v; want an empty history", none, err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	absent := health.Result{State: health.StateAbsent}
+	noStage := &reset.Report{Stages: []reset.StageOutcome{{Stage: "quick cycle", Skipped: "no port"}}}
+	tests := []struct {
+		check       health.Result
+		rep         *reset.Report
+		res         health.Result
+		heldOff     bool
+		interrupted bool
+		want        Outcome
+	}{
+		{healthy, nil, healthy, false, false, OutcomeHealthy},
+		{absent, nil, absent, false, false, OutcomeLeftAlone},
+		{wedged, nil, wedged, false, false, OutcomeUnresettable},
+		{wedged, nil, wedged, true, false, OutcomeHeldOff},
+		{wedged, noStage, health.Result{}, false, false, OutcomeUnresettable},
+		{wedged, fixedBy("full reset", "quick cycle", "full reset"), healthy, false, false, OutcomeFixed},
+		{wedged, fixedBy("", "quick cycle"), timeout, false, false, OutcomeFailed},
+		{wedged, fixedBy("", "quick cycle"), health.Result{}, false, true, OutcomeInterrupted},
+	}
+	for i, tt := range tests {
+		if got := Classify(tt.check, tt.rep, tt.res, tt.heldOff, tt.interrupted); got != tt.want {
+			t.Errorf("%d: Classify = %s, want %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	day := time.Date(2026, 10, 14, 9, 0, 0, 0, time.Local)
+	records := []Record{
+		{Time: day, Device: "desk", Trigger: "startup", Check: healthy},
+		{Time: day.Add(time.Hour), Device: "desk", Trigger: "wake", Check: wedged, Reset: fixedBy("", "quick cycle", "full reset")},
+		{Time: day.Add(time.Hour + time.Minute), Device: "desk", Trigger: "wake/retry-1", Retry: 1, Check: wedged, Reset: fixedBy("quick cycle", "quick cycle")},
+		{Time: day.Add(5 * time.Hour), Device: "desk", Trigger: "wake+usb-arrival", Check: timeout, Reset: fixedBy("quick cycle", "quick cycle")},
+		{Time: day.Add(26 * time.Hour), Device: "desk", Trigger: "wake", Check: wedged, Reset: fixedBy("full reset", "quick cycle", "full reset")},
+		// Another camera's incidents don't shorten desk's gaps.
+		{Time: day.Add(2 * time.Hour), Device: "overhead", Trigger: "usb-arrival", Check: wedged},
+	}
+	s := Summarize(records)
+
+	if s.Cycles != 6 || s.Incidents != 4 {
+		t.Errorf("cycles, incidents = %d, %d; want 6, 4", s.Cycles, s.Incidents)
+	}
+	wantDays := []Count{{"2026-10-14", 3}, {"2026-10-15", 1}}
+	if !slices.Equal(s.PerDay, wantDays) {
+		t.Errorf("PerDay = %v, want %v", s.PerDay, wantDays)
+	}
+	wantTriggers := []Count{{"wake", 3}, {"usb-arrival", 2}}
+	if !slices.Equal(s.Triggers, wantTriggers) {
+		t.Errorf("Triggers = %v, want %v", s.Triggers, wantTriggers)
+	}
+	wantSigs := []Count{{string(health.SigNoModes), 3}, {string(health.SigTimeout), 1}}
+	if !slices.Equal(s.Signatures, wantSigs) {
+		t.Errorf("Signatures = %v, want %v", s.Signatures, wantSigs)
+	}
+	wantStages := []StageStats{{"quick cycle", 4, 2}, {"full reset", 2, 1}}
+	if !slices.Equal(s.Stages, wantStages) {
+		t.Errorf("Stages = %v, want %v", s.Stages, wantStages)
+	}
+	// desk: 1h → 5h → 26h, gaps of 4h and 21h.
+	if want := 12*time.Hour + 30*time.Minute; s.MeanBetween != want {
+		t.Errorf("MeanBetween = %s, want %s", s.MeanBetween, want)
+	}
+}
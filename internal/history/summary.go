@@ -0,0 +1,126 @@
+package history
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Summary aggregates a stretch of history.
+type Summary struct {
+	Cycles    int `json:"cycles"`
+	Incidents int `json:"incidents"`
+	// PerDay counts incidents by local calendar day, "2006-01-02".
+	PerDay []Count `json:"per_day"`
+	// Triggers counts the events that opened incidents. A batch of
+	// several counts once for each.
+	Triggers []Count `json:"triggers"`
+	// Signatures counts how incidents' opening checks failed, most common
+	// first.
+	Signatures []Count `json:"signatures"`
+	// Stages is each reset stage's record, in order of first use.
+	Stages []StageStats `json:"stages"`
+	// MeanBetween is the mean time from one incident to the next on the
+	// same device, zero with fewer than two.
+	MeanBetween time.Duration `json:"mean_between,omitempty"`
+}
+
+// Count is how many times Key came up.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// StageStats is how a reset stage has fared.
+type StageStats struct {
+	Stage string `json:"stage"`
+	// Ran counts the times the stage ran (not skipped), and Fixed the
+	// times the device checked healthy after it.
+	Ran   int `json:"ran"`
+	Fixed int `json:"fixed"`
+}
+
+// SuccessRate is the fraction of runs that fixed the device.
+func (s StageStats) SuccessRate() float64 {
+	if s.Ran == 0 {
+		return 0
+	}
+	return float64(s.Fixed) / float64(s.Ran)
+}
+
+// Summarize aggregates records, which should be oldest first.
+func Summarize(records []Record) Summary {
+	s := Summary{Cycles: len(records)}
+	perDay := map[string]int{}
+	triggers := map[string]int{}
+	sigs := map[string]int{}
+	stages := map[string]*StageStats{}
+	var stageOrder []string
+	last := map[string]time.Time{}
+	var gaps time.Duration
+	var nGaps int
+
+	for _, r := range records {
+		if r.Reset != nil {
+			for _, st := range r.Reset.Stages {
+				if st.Skipped != "" {
+					continue
+				}
+				ss, ok := stages[st.Stage]
+				if !ok {
+					ss = &StageStats{Stage: st.Stage}
+					stages[st.Stage] = ss
+					stageOrder = append(stageOrder, st.Stage)
+				}
+				ss.Ran++
+				if r.Reset.FixedBy == st.Stage {
+					ss.Fixed++
+				}
+			}
+		}
+
+		if !r.Incident() {
+			continue
+		}
+		s.Incidents++
+		perDay[r.Time.Local().Format(time.DateOnly)]++
+		for _, t := range strings.Split(r.Trigger, "+") {
+			triggers[t]++
+		}
+		sigs[r.Failure()]++
+		if prev, ok := last[r.Device]; ok {
+			gaps += r.Time.Sub(prev)
+			nGaps++
+		}
+		last[r.Device] = r.Time
+	}
+
+	s.PerDay = counts(perDay)
+	slices.SortFunc(s.PerDay, func(a, b Count) int { return cmp.Compare(a.Key, b.Key) })
+	s.Triggers = byCount(counts(triggers))
+	s.Signatures = byCount(counts(sigs))
+	for _, name := range stageOrder {
+		s.Stages = append(s.Stages, *stages[name])
+	}
+	if nGaps > 0 {
+		s.MeanBetween = gaps / time.Duration(nGaps)
+	}
+	return s
+}
+
+func counts(m map[string]int) []Count {
+	out := make([]Count, 0, len(m))
+	for k, n := range m {
+		out = append(out, Count{Key: k, Count: n})
+	}
+	return out
+}
+
+// byCount sorts most common first, ties by key.
+func byCount(cs []Count) []Count {
+	slices.SortFunc(cs, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	return cs
+}
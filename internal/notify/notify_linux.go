@@ -0,0 +1,15 @@
+package notify
+
+import (
+	"log"
+	"os/exec"
+)
+
+// Send displays a desktop notification with the given message via
+// notify-send (libnotify), which talks to whatever notification daemon the
+// session runs.
+func Send(message string) {
+	if err := exec.Command("notify-send", "--app-name=camlink-fix", "Cam Link Fix", message).Run(); err != nil {
+		log.Printf("notify: notify-send failed: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package profile describes the capture hardware camlink-fix knows how to
+// look after: how to recognise each model, what it does with no HDMI input,
+// and which reset ladder suits it. A profile only supplies defaults; every
+// one of them can be overridden in the config file, which can also define
+// profiles of its own.
+package profile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/device"
+	"github.com/phinze/camlink-fix/internal/reset"
+)
+
+// Profile is one model of capture device.
+type Profile struct {
+	// Name is what --profile and the config's profile key select it by.
+	Name string
+	// DeviceName is the name the device shows up under in system_profiler
+	// and video4linux.
+	DeviceName string
+	// ID is the USB vendor:product pair. It's empty for a profile that
+	// covers many devices, in which case the config has to supply one, and
+	// for a device that isn't on USB.
+	ID string
+	// NotUSB marks a device that isn't on USB at all. The daemon can still
+	// check it and notify, but has no port to power-cycle.
+	NotUSB bool
+	// NoSignalMode is the mode the device falls back to with no HDMI
+	// input; see health.Config. Empty if it doesn't have a distinct one.
+	NoSignalMode string
+	// Stages is the reset ladder that suits the device. Nil means
+	// reset.DefaultStages.
+	Stages []reset.Stage
+}
+
+// Default is the profile used when none is chosen.
+const Default = "camlink-4k"
+
+var profiles = []Profile{
+	{
+		Name:       "camlink-4k",
+		DeviceName: "Cam Link 4K",
+		ID:         device.CamLink.ID(),
+		// With no HDMI source a Cam Link 4K falls back to its 4K30 pane.
+		NoSignalMode: "3840x2160@30",
+	},
+	{
+		// The Cam Link Pro is a PCIe card: it's found by name, and a wedge
+		// gets a notification rather than a reset.
+		Name:       "camlink-pro",
+		DeviceName: "Cam Link Pro",
+		NotUSB:     true,
+	},
+	{
+		// The HD60 S+'s USB ID and how it behaves without a source aren't
+		// on record, so as with generic-uvc the config supplies the ID and
+		// any no-signal mode. The ladder errs on the side of patience: each
+		// stage waits longer before checking than the Cam Link's.
+		Name:       "hd60-s-plus",
+		DeviceName: "Game Capture HD60 S+",
+		Stages: []reset.Stage{
+			{Name: "quick cycle", OffTime: 2 * time.Second, Kind: reset.KindPortCycle, Settle: 6 * time.Second},
+			{Name: "full reset", OffTime: 10 * time.Second, Kind: reset.KindBothPortsCycle, Settle: 10 * time.Second},
+			{Name: "extended reset", OffTime: 30 * time.Second, Kind: reset.KindBothPortsCycle, Settle: 10 * time.Second},
+		},
+	},
+	{
+		// Any other UVC capture device. There's no telling its ID or how
+		// it behaves without a source, so the config supplies the ID and,
+		// if it has one, the no-signal mode.
+		Name:       "generic-uvc",
+		DeviceName: "USB Video",
+	},
+}
+
+// Lookup returns the profile called name, from extra (the config file's
+// own) if it's there and from the built-in ones if not.
+func Lookup(name string, extra []Profile) (Profile, error) {
+	for _, p := range slices.Concat(extra, profiles) {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q (want one of %s)", name, strings.Join(Names(extra), ", "))
+}
+
+// Names lists the built-in profiles followed by those in extra, each name
+// once.
+func Names(extra []Profile) []string {
+	var names []string
+	for _, p := range slices.Concat(profiles, extra) {
+		if !slices.Contains(names, p.Name) {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
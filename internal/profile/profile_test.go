@@ -0,0 +1,55 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+func TestProfilesAreUsable(t *testing.T) {
+	seen := map[string]bool{}
+	for _, p := range profiles {
+		if seen[p.Name] {
+			t.Errorf("profile %q listed twice", p.Name)
+		}
+		seen[p.Name] = true
+		if p.DeviceName == "" {
+			t.Errorf("%s: no device name", p.Name)
+		}
+		if p.ID != "" {
+			if p.NotUSB {
+				t.Errorf("%s: has a USB ID but isn't on USB", p.Name)
+			}
+			if _, _, err := device.ParseID(p.ID); err != nil {
+				t.Errorf("%s: %v", p.Name, err)
+			}
+		}
+		for _, s := range p.Stages {
+			if s.Name == "" || s.OffTime <= 0 || s.Settle < 0 {
+				t.Errorf("%s: bad stage %+v", p.Name, s)
+			}
+		}
+	}
+	if _, err := Lookup(Default, nil); err != nil {
+		t.Errorf("default profile: %v", err)
+	}
+}
+
+func TestLookupUnknown(t *testing.T) {
+	if _, err := Lookup("camlink", nil); err == nil {
+		t.Error("Lookup(camlink) should fail")
+	}
+}
+
+func TestLookupExtra(t *testing.T) {
+	extra := []Profile{{Name: "hd60-x", DeviceName: "Game Capture HD60 X"}, {Name: "camlink-4k", DeviceName: "Office Cam Link"}}
+	if p, err := Lookup("hd60-x", extra); err != nil || p.DeviceName != "Game Capture HD60 X" {
+		t.Errorf("Lookup(hd60-x) = %+v, %v", p, err)
+	}
+	if p, err := Lookup("camlink-4k", extra); err != nil || p.DeviceName != "Office Cam Link" {
+		t.Errorf("Lookup(camlink-4k) = %+v, %v; want the extra one over the built-in", p, err)
+	}
+	if names := Names(extra); len(names) != len(profiles)+1 || names[len(names)-1] != "hd60-x" {
+		t.Errorf("Names = %q, want the built-in ones then hd60-x", names)
+	}
+}
@@ -0,0 +1,12 @@
+package reset
+
+import "syscall"
+
+// systemBootID is the kernel's per-boot session UUID.
+func systemBootID() string {
+	id, err := syscall.Sysctl("kern.bootsessionuuid")
+	if err != nil {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,15 @@
+package reset
+
+import (
+	"os"
+	"strings"
+)
+
+// systemBootID is the kernel's random per-boot UUID.
+func systemBootID() string {
+	b, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,54 @@
+package reset
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// TopologyCache holds one uhubctl scan for reuse. Enumerating a big dock
+// tree takes uhubctl a while, so a fix cycle scans once and shares the
+// result between locating the camera, finding its companion hub and judging
+// what else a power stage would cut off — until Invalidate says the bus has
+// changed, or the next cycle starts and wants a scan of its own.
+//
+// The zero value is an empty cache, ready to use.
+type TopologyCache struct {
+	mu      sync.Mutex
+	path    string
+	topo    Topology
+	scanned time.Time
+	valid   bool
+}
+
+// Get returns the cached topology, scanning with uhubctlPath first if there
+// isn't one (or it came from a different uhubctl). Concurrent callers share
+// a single scan.
+func (c *TopologyCache) Get(uhubctlPath string) (Topology, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.valid && c.path == uhubctlPath {
+		log.Printf("reset: reusing USB topology scanned %s ago", time.Since(c.scanned).Round(time.Millisecond))
+		return c.topo, nil
+	}
+
+	start := time.Now()
+	t, err := ScanTopology(uhubctlPath)
+	if err != nil {
+		return Topology{}, err
+	}
+	log.Printf("reset: scanned USB topology in %s (%d hubs)", time.Since(start).Round(time.Millisecond), len(t.Hubs))
+
+	c.path, c.topo, c.scanned, c.valid = uhubctlPath, t, start, true
+	return t, nil
+}
+
+// Invalidate drops the cached topology so the next Get scans afresh. If a
+// scan is in flight it waits for it, then drops that too, since the scan
+// may have raced whatever prompted the call.
+func (c *TopologyCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valid = false
+}
@@ -0,0 +1,80 @@
+package reset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingUhubctl writes a uhubctl stand-in that prints a captured report and
+// counts its invocations in a file, returning its path and a counter.
+func countingUhubctl(t *testing.T, capture string) (string, func() int) {
+	t.Helper()
+	dir := t.TempDir()
+	report, err := filepath.Abs(filepath.Join("testdata", "uhubctl", capture+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "uhubctl")
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho x >> " + calls + "\ncat " + report + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, func() int {
+		data, _ := os.ReadFile(calls)
+		return len(data) / 2
+	}
+}
+
+func TestTopologyCacheScansOnce(t *testing.T) {
+	bin, scans := countingUhubctl(t, "via-vl817-dock")
+	var c TopologyCache
+
+	for range 3 {
+		topo, err := c.Get(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(topo.Hubs) != 3 {
+			t.Fatalf("got %d hubs, want 3", len(topo.Hubs))
+		}
+	}
+	if n := scans(); n != 1 {
+		t.Errorf("uhubctl ran %d times for 3 lookups, want 1", n)
+	}
+
+	c.Invalidate()
+	if _, err := c.Get(bin); err != nil {
+		t.Fatal(err)
+	}
+	if n := scans(); n != 2 {
+		t.Errorf("uhubctl ran %d times after Invalidate, want 2", n)
+	}
+
+	// A config reload pointing at a different uhubctl doesn't get the old
+	// binary's answer.
+	other, otherScans := countingUhubctl(t, "rpi4")
+	topo, err := c.Get(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherScans() != 1 || topo.Hubs[0].Location != "2" {
+		t.Errorf("Get with a new uhubctl path reused the old scan")
+	}
+}
+
+func TestTopologyCacheDoesNotCacheFailures(t *testing.T) {
+	var c TopologyCache
+	if _, err := c.Get(filepath.Join(t.TempDir(), "no-such-uhubctl")); err == nil {
+		t.Fatal("Get with a missing uhubctl should fail")
+	}
+
+	bin, scans := countingUhubctl(t, "rpi4")
+	if _, err := c.Get(bin); err != nil {
+		t.Fatal(err)
+	}
+	if scans() != 1 {
+		t.Error("expected a fresh scan after a failed one")
+	}
+}
@@ -3,78 +3,86 @@ package reset
 import (
 	"fmt"
 	"log"
-	"os/exec"
-	"regexp"
+	"path/filepath"
+	"runtime"
 	"strings"
+
+	"github.com/phinze/camlink-fix/internal/device"
 )
 
 // Location identifies where a device is in the USB hub tree.
 type Location struct {
-	Hub  string
-	Port string
+	Hub  string `json:"hub"`
+	Port string `json:"port"`
 }
 
-var (
-	hubRe  = regexp.MustCompile(`^Current status for hub ([0-9.\-]+)\s+\[([0-9a-f:]+)`)
-	portRe = regexp.MustCompile(`^\s+Port\s+(\d+):`)
-)
-
-// FindCamLink discovers the Cam Link's hub location and port by parsing
-// uhubctl output. Returns the location or an error if not found.
-func FindCamLink(uhubctlPath string) (Location, error) {
-	out, err := exec.Command(uhubctlPath).Output()
-	if err != nil {
-		// uhubctl may return non-zero even on success; use combined output
-		out, err = exec.Command(uhubctlPath).CombinedOutput()
-		if err != nil && len(out) == 0 {
-			return Location{}, fmt.Errorf("uhubctl failed: %w", err)
+// Locate finds the hub location and port of the device sel picks out in a
+// uhubctl topology. Returns the location or an error if not found.
+func Locate(t Topology, sel device.Selector) (Location, error) {
+	loc, ok := t.FindDevice(func(d Device) bool {
+		// uhubctl prints the serial number last, after the manufacturer and
+		// product strings, with nothing to set it apart.
+		var serial string
+		if sel.Serial != "" && strings.HasSuffix(d.Description, " "+sel.Serial) {
+			serial = sel.Serial
 		}
+		return sel.Matches(d.ID.Vendor, d.ID.Product, serial, d.Description)
+	})
+	if !ok {
+		return Location{}, fmt.Errorf("device %s not found in USB hub tree", sel)
 	}
+	return loc, nil
+}
 
-	var currentHub string
-	for _, line := range strings.Split(string(out), "\n") {
-		if m := hubRe.FindStringSubmatch(line); m != nil {
-			currentHub = m[1]
-		}
-		if m := portRe.FindStringSubmatch(line); m != nil {
-			port := m[1]
-			if strings.Contains(line, "Cam Link") && currentHub != "" {
-				return Location{Hub: currentHub, Port: port}, nil
-			}
-		}
+// FindCompanion finds the other half of loc's port. A USB3 hub is really two
+// hubs in one package, a USB3 one and a USB2 one, each with its own spot in
+// the tree; to really cut power to a port, both halves have to be switched.
+//
+// Only the kernel knows which ports are the same physical port: it pairs
+// them up (from ACPI for root hubs, and through the hub's own upstream port
+// below that) and says so with a port/peer link in env's sysfs. uhubctl's
+// report has nothing to go on, no container ID and often no hub serial, and
+// guessing from vendor and tree position can pick a hub that isn't the
+// other half, so without a peer link there's no companion.
+// Returns false, after logging why, if there isn't one.
+func FindCompanion(env Env, loc Location) (Location, bool) {
+	if peer, ok := peerPort(env.sysfs(), loc); ok {
+		log.Printf("reset: companion of %s port %s is %s port %s (sysfs port peer)", loc.Hub, loc.Port, peer.Hub, peer.Port)
+		return peer, true
 	}
-
-	return Location{}, fmt.Errorf("Cam Link not found in USB hub tree")
+	if runtime.GOOS != "linux" {
+		log.Printf("reset: no companion for hub %s: companion discovery is unsupported on %s, which has no port peer links", loc.Hub, runtime.GOOS)
+		return Location{}, false
+	}
+	log.Printf("reset: no companion for hub %s: sysfs has no port peer for port %s", loc.Hub, loc.Port)
+	return Location{}, false
 }
 
-// FindCompanionHub finds the companion USB 2.0/3.0 hub for a given hub
-// location. VIA Labs hubs have USB2 (2109:2813) and USB3 (2109:0813)
-// companions that share port topology.
-func FindCompanionHub(uhubctlPath string, loc Location) string {
-	out, err := exec.Command(uhubctlPath).CombinedOutput()
-	if err != nil && len(out) == 0 {
-		return ""
+// peerPort follows the sysfs peer link of loc's port, if there is one.
+func peerPort(root string, loc Location) (Location, bool) {
+	target, err := filepath.EvalSymlinks(filepath.Join(portDir(root, loc), "peer"))
+	if err != nil {
+		return Location{}, false
 	}
-
-	var currentHub, currentVID string
-	for _, line := range strings.Split(string(out), "\n") {
-		if m := hubRe.FindStringSubmatch(line); m != nil {
-			currentHub = m[1]
-			currentVID = m[2]
-		}
-
-		// Look for VIA Labs companion hubs
-		if currentVID == "2109:2813" || currentVID == "2109:0813" {
-			if currentHub != loc.Hub {
-				if m := portRe.FindStringSubmatch(line); m != nil {
-					if m[1] == loc.Port {
-						log.Printf("reset: found companion hub at %s", currentHub)
-						return currentHub
-					}
-				}
-			}
-		}
+	// The target is another port directory, named like the one we started
+	// from: "<hub>-port<n>", or "usb<bus>-port<n>" on a root hub.
+	hub, port, ok := strings.Cut(filepath.Base(target), "-port")
+	if !ok || hub == "" || port == "" {
+		return Location{}, false
+	}
+	if bus, ok := strings.CutPrefix(hub, "usb"); ok && !strings.Contains(bus, "-") {
+		hub = bus
 	}
+	return Location{Hub: hub, Port: port}, true
+}
 
-	return ""
+// portDir is the sysfs directory of loc's port, e.g.
+// /sys/bus/usb/devices/2-1/2-1:1.0/2-1-port4, or for a root hub
+// /sys/bus/usb/devices/usb2/2-0:1.0/usb2-port4.
+func portDir(root string, loc Location) string {
+	dev, iface, prefix := loc.Hub, loc.Hub+":1.0", loc.Hub
+	if !strings.Contains(loc.Hub, "-") {
+		dev, iface, prefix = "usb"+loc.Hub, loc.Hub+"-0:1.0", "usb"+loc.Hub
+	}
+	return filepath.Join(root, "bus", "usb", "devices", dev, iface, prefix+"-port"+loc.Port)
 }
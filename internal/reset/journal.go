@@ -0,0 +1,381 @@
+package reset
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalVersion is the journal's schema version. A journal with another
+// version is ignored rather than misread.
+const journalVersion = 1
+
+// bootID identifies the running boot. A reboot re-powers USB, so only
+// entries written in this boot are healed. Overridden in tests.
+var bootID = systemBootID
+
+// journal returns env's reset journal path, defaulting to journal.json in
+// camlink-fix's XDG state directory, $XDG_STATE_HOME/camlink-fix or
+// ~/.local/state/camlink-fix.
+//
+// For each managed device the journal holds where a reset last found it
+// and, written ahead of every power-off, which ports are going dark; the
+// entry is marked restored once they're back on. An entry still unrestored
+// at startup is a reset that was killed mid-cycle, leaving the camera dark,
+// and Heal puts it right.
+func (env Env) journal() string {
+	if env.JournalPath != "" {
+		return env.JournalPath
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "camlink-fix.journal.json")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "camlink-fix", "journal.json")
+}
+
+// journal is the journal file's contents.
+type journal struct {
+	Version int                     `json:"version"`
+	Devices map[string]journalEntry `json:"devices"`
+}
+
+// journalEntry is the journal's record of one device.
+type journalEntry struct {
+	Location  Location `json:"location"`
+	Companion Location `json:"companion,omitzero"`
+	// BootID is the boot Off was written in.
+	BootID string `json:"boot_id,omitempty"`
+	// Off are the ports the last power-off switched, and Restored whether
+	// they've been switched back on since.
+	Off      []journalPort `json:"off,omitempty"`
+	Restored bool          `json:"restored"`
+	At       time.Time     `json:"at,omitzero"`
+}
+
+// journalPort is a port that went off, and which hub it was on.
+type journalPort struct {
+	Location
+	// HubID is the hub's vendor:product, so that a different hub turning
+	// up at the same place in the tree (another dock) isn't touched.
+	HubID string `json:"hub_id,omitempty"`
+}
+
+// unfinished reports whether e records ports going off in this boot that
+// never came back on.
+func (e journalEntry) unfinished(boot string) bool {
+	return len(e.Off) > 0 && !e.Restored && boot != "" && e.BootID == boot
+}
+
+// journalMu serializes read-modify-write cycles of the journal file, for
+// cameras resetting at the same time.
+var journalMu sync.Mutex
+
+// loadJournal reads the journal at path. A missing or unreadable one is
+// empty.
+func loadJournal(path string) journal {
+	j := journal{Version: journalVersion, Devices: map[string]journalEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return j
+	}
+	var read journal
+	if err := json.Unmarshal(data, &read); err != nil {
+		log.Printf("reset: ignoring unreadable journal %s: %v", path, err)
+		return j
+	}
+	if read.Version != journalVersion {
+		log.Printf("reset: ignoring journal %s with schema version %d (want %d)", path, read.Version, journalVersion)
+		return j
+	}
+	if read.Devices != nil {
+		j.Devices = read.Devices
+	}
+	return j
+}
+
+// save writes j to path atomically: to a temp file alongside, then renamed
+// over the journal, so a crash mid-write leaves the old journal, not half
+// of the new one.
+func (j journal) save(path string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".journal-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// updateJournal applies update to name's entry and writes env's journal.
+func updateJournal(env Env, name string, update func(e *journalEntry)) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	j := loadJournal(env.journal())
+	e := j.Devices[name]
+	update(&e)
+	j.Devices[name] = e
+	return j.save(env.journal())
+}
+
+// recordLocation journals where a reset found t, for status and so a
+// later power-off has it.
+func recordLocation(env Env, t Target) {
+	err := updateJournal(env, t.Name, func(e *journalEntry) {
+		e.Location, e.Companion = t.Location, t.Companion
+	})
+	if err != nil {
+		log.Printf("reset: could not journal location: %v", err)
+	}
+}
+
+// journalOff records, before they go, that ports are about to be switched
+// off for t.
+func journalOff(env Env, t Target, ports []Location) {
+	off := make([]journalPort, len(ports))
+	for i, p := range ports {
+		off[i] = journalPort{Location: p, HubID: hubID(env, t.Topology, p.Hub)}
+	}
+	err := updateJournal(env, t.Name, func(e *journalEntry) {
+		e.BootID, e.Off, e.Restored, e.At = bootID(), off, false, time.Now()
+	})
+	if err != nil {
+		log.Printf("reset: could not journal ports going off, so a killed reset won't be healed: %v", err)
+	}
+}
+
+// journalRestored records that name's ports are back on.
+func journalRestored(env Env, name string) {
+	err := updateJournal(env, name, func(e *journalEntry) {
+		e.Restored, e.At = true, time.Now()
+	})
+	if err != nil {
+		log.Printf("reset: could not journal ports restored: %v", err)
+	}
+}
+
+// hubID is the vendor:product of the hub at location, from topo or else
+// sysfs, or "" if neither knows it.
+func hubID(env Env, topo Topology, location string) string {
+	if h, ok := topo.Hub(location); ok {
+		return h.ID.String()
+	}
+	name := location
+	if !strings.Contains(location, "-") {
+		name = "usb" + location
+	}
+	dir := filepath.Join(env.sysfs(), "bus", "usb", "devices", name)
+	vendor, product := readSysfsAttr(dir, "idVendor"), readSysfsAttr(dir, "idProduct")
+	if vendor == "" || product == "" {
+		return ""
+	}
+	return vendor + ":" + product
+}
+
+// SavedLocation is the last place a reset found a device.
+type SavedLocation struct {
+	Hub       string `json:"hub"`
+	Port      string `json:"port"`
+	Companion string `json:"companion"`
+	// CompanionPort is the port on Companion.
+	CompanionPort string `json:"companion_port,omitempty"`
+}
+
+// LastLocation returns where the most recent reset of the named device
+// found it, if one has, from env's journal.
+func LastLocation(env Env, name string) (SavedLocation, bool) {
+	journalMu.Lock()
+	e, ok := loadJournal(env.journal()).Devices[name]
+	journalMu.Unlock()
+	if !ok || e.Location.Hub == "" || e.Location.Port == "" {
+		return SavedLocation{}, false
+	}
+	return SavedLocation{
+		Hub:           e.Location.Hub,
+		Port:          e.Location.Port,
+		Companion:     e.Companion.Hub,
+		CompanionPort: e.Companion.Port,
+	}, true
+}
+
+// HealReport is what healing one device's unfinished reset did.
+type HealReport struct {
+	Device string
+	// Restored are the ports switched back on, and reading powered
+	// afterwards unless Unverified says why that couldn't be checked.
+	Restored []Location
+	// Skipped are ports left alone, with why.
+	Skipped []string
+	// StillOff are ports that couldn't be switched on, or didn't read
+	// powered afterwards.
+	StillOff []Location
+	// Unverified says why the ports' power couldn't be read back, if it
+	// couldn't.
+	Unverified string
+}
+
+func (r HealReport) String() string {
+	var parts []string
+	if len(r.Restored) > 0 {
+		parts = append(parts, "powered on "+joinLocations(r.Restored))
+	}
+	if len(r.Skipped) > 0 {
+		parts = append(parts, "skipped "+strings.Join(r.Skipped, ", "))
+	}
+	if len(r.StillOff) > 0 {
+		parts = append(parts, "still off: "+joinLocations(r.StillOff))
+	}
+	if r.Unverified != "" {
+		parts = append(parts, "not verified: "+r.Unverified)
+	}
+	if len(parts) == 0 {
+		return "nothing to do"
+	}
+	return strings.Join(parts, "; ")
+}
+
+func joinLocations(locs []Location) string {
+	s := make([]string, len(locs))
+	for i, l := range locs {
+		s[i] = l.Hub + " port " + l.Port
+	}
+	return strings.Join(s, ", ")
+}
+
+// Heal powers back on the ports of every device whose reset was cut off
+// mid-cycle in this boot (crash, SIGKILL) — the exact way an aborted reset
+// strands the camera dark. KeepAlive restarts the daemon, startup calls
+// Heal, and the ports come back. Entries from an earlier boot are left
+// alone, as are ports whose hub has since been swapped for another.
+// Returns a report per device it acted on.
+func Heal(env Env) []HealReport {
+	journalMu.Lock()
+	j := loadJournal(env.journal())
+	journalMu.Unlock()
+
+	names := make([]string, 0, len(j.Devices))
+	for name := range j.Devices {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var reports []HealReport
+	boot := bootID()
+	for _, name := range names {
+		if e := j.Devices[name]; e.unfinished(boot) {
+			reports = append(reports, heal(env, name, e))
+		}
+	}
+	return reports
+}
+
+// HealDevice heals the named device's unfinished reset, if it has one in
+// this boot.
+func HealDevice(env Env, name string) (HealReport, bool) {
+	journalMu.Lock()
+	e, ok := loadJournal(env.journal()).Devices[name]
+	journalMu.Unlock()
+	if !ok || !e.unfinished(bootID()) {
+		return HealReport{Device: name}, false
+	}
+	return heal(env, name, e), true
+}
+
+func heal(env Env, name string, e journalEntry) HealReport {
+	rep := HealReport{Device: name}
+	var topo *Topology
+	current := func(hub string) string {
+		// Sysfs first; scan uhubctl's tree only if that doesn't know.
+		if id := hubID(env, Topology{}, hub); id != "" {
+			return id
+		}
+		if topo == nil {
+			t, _ := ScanTopology(env.UhubctlPath)
+			topo = &t
+		}
+		return hubID(env, *topo, hub)
+	}
+
+	for _, p := range e.Off {
+		if p.HubID != "" {
+			if id := current(p.Hub); id != p.HubID {
+				rep.Skipped = append(rep.Skipped, fmt.Sprintf("%s port %s (hub was %s, now %s)", p.Hub, p.Port, p.HubID, orUnknown(id)))
+				continue
+			}
+		}
+		if err := setPower(env, p.Location, true); err != nil {
+			log.Printf("reset: %v", err)
+			rep.StillOff = append(rep.StillOff, p.Location)
+			continue
+		}
+		rep.Restored = append(rep.Restored, p.Location)
+	}
+
+	// A port that reads back as off moves from Restored to StillOff. Once
+	// one can't be read at all, the rest stay Restored, unverified.
+	var verified []Location
+	for i, l := range rep.Restored {
+		on, err := env.portSwitch(l).powered(l)
+		if err != nil {
+			rep.Unverified = err.Error()
+			verified = append(verified, rep.Restored[i:]...)
+			break
+		}
+		if !on {
+			rep.StillOff = append(rep.StillOff, l)
+			continue
+		}
+		verified = append(verified, l)
+	}
+	rep.Restored = verified
+
+	if len(rep.StillOff) > 0 {
+		log.Printf("ERROR: reset: healing %s: %s", name, rep)
+		return rep
+	}
+	log.Printf("reset: healed %s's interrupted reset: %s", name, rep)
+	journalRestored(env, name)
+	return rep
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// portNumber parses l's port, for looking it up in a Hub.
+func (l Location) portNumber() int {
+	n, _ := strconv.Atoi(l.Port)
+	return n
+}
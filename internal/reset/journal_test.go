@@ -0,0 +1,187 @@
+package reset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// journalFixture sets up an env with a temp journal and a sysfs tree with a dock's two
+// hub halves, and a reset that got killed with the camera's ports off.
+func journalFixture(t *testing.T) (env Env, attrs []string) {
+	t.Helper()
+	bootID = func() string { return "boot-a" }
+	t.Cleanup(func() { bootID = systemBootID })
+
+	root := t.TempDir()
+	devices := filepath.Join(root, "bus", "usb", "devices")
+	for hub, id := range map[string][2]string{"4-1": {"2109", "0813"}, "3-1": {"2109", "2813"}} {
+		if err := os.MkdirAll(filepath.Join(devices, hub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(devices, hub, "idVendor"), []byte(id[0]+"\n"), 0o644)
+		os.WriteFile(filepath.Join(devices, hub, "idProduct"), []byte(id[1]+"\n"), 0o644)
+	}
+	port, companion := Location{Hub: "4-1", Port: "2"}, Location{Hub: "3-1", Port: "2"}
+	attrs = []string{fakePort(t, root, port), fakePort(t, root, companion)}
+	env = Env{SysfsRoot: root, PortControl: PortControlSysfs, JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+
+	tgt := Target{Name: "cam", Location: port, Companion: companion}
+	recordLocation(env, tgt)
+	journalOff(env, tgt, []Location{port, companion})
+	for _, attr := range attrs {
+		writeAttr(attr, "1")
+	}
+	return env, attrs
+}
+
+func TestHealUnfinishedReset(t *testing.T) {
+	env, attrs := journalFixture(t)
+
+	reports := Heal(env)
+	if len(reports) != 1 || reports[0].Device != "cam" || len(reports[0].Restored) != 2 ||
+		len(reports[0].StillOff) != 0 || reports[0].Unverified != "" {
+		t.Fatalf("Heal = %+v, want both ports restored and verified", reports)
+	}
+	for _, attr := range attrs {
+		if got := readFile(t, attr); got != "0" {
+			t.Errorf("%s = %q after Heal, want 0", attr, got)
+		}
+	}
+	if again := Heal(env); len(again) != 0 {
+		t.Errorf("second Heal = %+v, want nothing left to heal", again)
+	}
+	if loc, ok := LastLocation(env, "cam"); !ok || loc.Hub != "4-1" || loc.Companion != "3-1" {
+		t.Errorf("LastLocation = %+v, %v", loc, ok)
+	}
+}
+
+func TestHealIgnoresEarlierBoot(t *testing.T) {
+	env, attrs := journalFixture(t)
+	bootID = func() string { return "boot-b" }
+
+	if reports := Heal(env); len(reports) != 0 {
+		t.Errorf("Heal = %+v, want an earlier boot's entry left alone", reports)
+	}
+	if got := readFile(t, attrs[0]); got != "1" {
+		t.Errorf("port touched: disable = %q", got)
+	}
+}
+
+func TestHealSkipsSwappedHub(t *testing.T) {
+	env, attrs := journalFixture(t)
+	// Another dock, with a different hub, now sits at 4-1.
+	os.WriteFile(filepath.Join(env.SysfsRoot, "bus", "usb", "devices", "4-1", "idProduct"), []byte("0817\n"), 0o644)
+
+	rep, ok := HealDevice(env, "cam")
+	if !ok || len(rep.Skipped) != 1 || !slices.Equal(rep.Restored, []Location{{Hub: "3-1", Port: "2"}}) {
+		t.Errorf("HealDevice = %+v, %v; want 4-1 skipped and 3-1 restored", rep, ok)
+	}
+	if got := readFile(t, attrs[0]); got != "1" {
+		t.Errorf("swapped hub's port touched: disable = %q", got)
+	}
+}
+
+func TestHealReportsPortsStillOff(t *testing.T) {
+	env, attrs := journalFixture(t)
+	// A disable attribute that can't be written.
+	os.Remove(attrs[1])
+	os.Mkdir(attrs[1], 0o755)
+
+	rep, _ := HealDevice(env, "cam")
+	if len(rep.Restored) != 1 || !slices.Equal(rep.StillOff, []Location{{Hub: "3-1", Port: "2"}}) {
+		t.Errorf("HealDevice = %+v, want 3-1 port 2 reported still off", rep)
+	}
+	if _, ok := HealDevice(env, "cam"); !ok {
+		t.Error("entry marked restored though a port may still be off")
+	}
+}
+
+func TestHealReportsPortsReadingOff(t *testing.T) {
+	env, _ := journalFixture(t)
+	// A uhubctl whose power-on goes through, but whose scan still shows
+	// 4-1 port 2 off.
+	bin := filepath.Join(t.TempDir(), "uhubctl")
+	report := "Current status for hub 4-1 [2109:0813 VIA Labs, Inc. USB3.0 Hub, USB 3.10, 4 ports, ppps]\n" +
+		"  Port 2: 0000 off\n" +
+		"Current status for hub 3-1 [2109:2813 VIA Labs, Inc. USB2.0 Hub, USB 2.10, 4 ports, ppps]\n" +
+		"  Port 2: 0100 power\n"
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nprintf '"+report+"'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env.PortControl, env.UhubctlPath = PortControlUhubctl, bin
+
+	rep, _ := HealDevice(env, "cam")
+	if !slices.Equal(rep.Restored, []Location{{Hub: "3-1", Port: "2"}}) || !slices.Equal(rep.StillOff, []Location{{Hub: "4-1", Port: "2"}}) {
+		t.Errorf("HealDevice = %+v, want 4-1 port 2 moved from restored to still off", rep)
+	}
+}
+
+func TestJournalIgnoresOtherVersion(t *testing.T) {
+	env := Env{JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+	future := `{"version":2,"devices":{"cam":{"location":{"hub":"4-1","port":"2"}}}}`
+	if err := os.WriteFile(env.JournalPath, []byte(future), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LastLocation(env, "cam"); ok {
+		t.Error("LastLocation read a journal with another schema version")
+	}
+}
+
+func TestCyclePortsJournalsAhead(t *testing.T) {
+	bin, _ := fakeUhubctl(t)
+	env := Env{UhubctlPath: bin, PortControl: PortControlUhubctl, JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+	tgt := Target{Name: "cam", Location: Location{Hub: "4-1", Port: "2"}}
+
+	// Read the journal from inside the off window.
+	ctx, cancel := context.WithCancel(context.Background())
+	var during journalEntry
+	go func() {
+		defer cancel()
+		for range 500 {
+			journalMu.Lock()
+			e := loadJournal(env.JournalPath).Devices["cam"]
+			journalMu.Unlock()
+			if len(e.Off) > 0 {
+				during = e
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	cyclePorts(ctx, env, tgt, time.Minute, tgt.Location)
+
+	if len(during.Off) != 1 || during.Off[0].Location != tgt.Location || during.Restored {
+		t.Errorf("journal during off window = %+v, want the port recorded as off", during)
+	}
+	e := loadJournal(env.JournalPath).Devices["cam"]
+	if !e.Restored {
+		t.Errorf("journal after cycle = %+v, want restored", e)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(env.JournalPath))
+	if len(entries) != 1 {
+		t.Errorf("journal dir has %d entries, want just the journal (no temp files left)", len(entries))
+	}
+}
+
+func TestCyclePortsKeepsFailedPowerOnJournaled(t *testing.T) {
+	bootID = func() string { return "boot-a" }
+	t.Cleanup(func() { bootID = systemBootID })
+	root := t.TempDir()
+	port, companion := Location{Hub: "2-1", Port: "4"}, Location{Hub: "1", Port: "4"}
+	fakePort(t, root, port)
+	// A disable attribute that can't be written.
+	attr := fakePort(t, root, companion)
+	os.Remove(attr)
+	os.Mkdir(attr, 0o755)
+	env := Env{SysfsRoot: root, PortControl: PortControlSysfs, JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+
+	cyclePorts(context.Background(), env, Target{Name: "cam", Location: port, Companion: companion}, time.Millisecond, port, companion)
+
+	if e := loadJournal(env.JournalPath).Devices["cam"]; e.Restored || !e.unfinished("boot-a") {
+		t.Errorf("journal after a failed power-on = %+v, want it left unfinished for Heal", e)
+	}
+}
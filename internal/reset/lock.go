@@ -0,0 +1,53 @@
+package reset
+
+import (
+	"slices"
+	"sync"
+)
+
+// HubLocks serializes power-cycling per hub, so two devices that share a hub
+// are never reset at the same time — cutting power on one port of a hub that
+// is mid-way through re-enumerating another is asking for trouble, and on a
+// ganged hub both would be switched anyway.
+//
+// The zero value is ready to use.
+type HubLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// Lock blocks until it holds every one of hubs, and returns a function that
+// releases them. Hubs are always taken in sorted order, so two callers
+// wanting overlapping sets can't deadlock. Empty names are ignored.
+func (l *HubLocks) Lock(hubs ...string) (unlock func()) {
+	hubs = slices.Clone(hubs)
+	hubs = slices.DeleteFunc(hubs, func(h string) bool { return h == "" })
+	slices.Sort(hubs)
+	hubs = slices.Compact(hubs)
+
+	held := make([]*sync.Mutex, 0, len(hubs))
+	for _, h := range hubs {
+		m := l.lock(h)
+		m.Lock()
+		held = append(held, m)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+func (l *HubLocks) lock(hub string) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = map[string]*sync.Mutex{}
+	}
+	m, ok := l.locks[hub]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[hub] = m
+	}
+	return m
+}
@@ -0,0 +1,49 @@
+package reset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHubLocksSerializeSharedHub(t *testing.T) {
+	var l HubLocks
+	unlock := l.Lock("2-1", "1-1")
+
+	acquired := make(chan struct{})
+	go func() {
+		// Overlaps on 1-1, listed in the other order.
+		defer l.Lock("1-1", "3-1")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second reset got 1-1 while the first held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A disjoint hub isn't held up.
+	l.Lock("4-1")()
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second reset never got its hubs after the first released them")
+	}
+}
+
+func TestHubLocksIgnoresDuplicatesAndEmpty(t *testing.T) {
+	var l HubLocks
+	done := make(chan struct{})
+	go func() {
+		// A target with no companion, or one whose companion is its own
+		// hub, mustn't deadlock on itself.
+		l.Lock("2-1", "", "2-1")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lock deadlocked on a repeated hub")
+	}
+}
@@ -0,0 +1,124 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KindParentHubCycle powers off the port the device's hub hangs off, and
+// with it the hub and everything plugged into it. It's the last resort for
+// docks whose ports stay wedged through a port cycle, and it's never in a
+// built-in ladder: a config has to ask for it.
+const KindParentHubCycle = "parent-hub-cycle"
+
+func init() {
+	strategies[KindParentHubCycle] = func(s Stage) (Strategy, error) {
+		if s.OffTime <= 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("must be positive, got %s", s.OffTime)}
+		}
+		return parentHubCycle{off: s.OffTime}, nil
+	}
+}
+
+// upstreamPort returns the port hub is plugged into: port 4 of hub 2-1 for
+// "2-1.4", port 1 of root hub 2 for "2-1". Root hubs have none.
+func upstreamPort(hub string) (Location, bool) {
+	i := strings.LastIndexAny(hub, ".-")
+	if i < 0 {
+		return Location{}, false
+	}
+	return Location{Hub: hub[:i], Port: hub[i+1:]}, true
+}
+
+// parentPorts returns the upstream ports of t's hub and of its companion's,
+// which differ: the two halves of a USB3 hub hang off different halves of
+// the port above.
+func (t Target) parentPorts() []Location {
+	var ports []Location
+	for _, hub := range []string{t.Location.Hub, t.Companion.Hub} {
+		if p, ok := upstreamPort(hub); ok && hub != "" && !slices.Contains(ports, p) {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// poweredWith lists every device the topology shows on hubs or any hub
+// below them, other than those at skip.
+func poweredWith(topo Topology, hubs []string, skip ...Location) []string {
+	var devices []string
+	for _, h := range topo.Hubs {
+		below := slices.ContainsFunc(hubs, func(hub string) bool {
+			return h.Location == hub || strings.HasPrefix(h.Location, hub+".")
+		})
+		if !below {
+			continue
+		}
+		for _, p := range h.Ports {
+			loc := Location{Hub: h.Location, Port: strconv.Itoa(p.Number)}
+			if p.Device == nil || slices.Contains(skip, loc) {
+				continue
+			}
+			devices = append(devices, fmt.Sprintf("%s port %d: %s", h.Location, p.Number, p.Device.describe()))
+		}
+	}
+	return devices
+}
+
+// parentHubCycle power-cycles the upstream port of the device's hub.
+type parentHubCycle struct {
+	off time.Duration
+}
+
+func (parentHubCycle) Kind() string { return KindParentHubCycle }
+
+// Applicable requires t's hub to be in the topology as well as to have a
+// parent: without the scan there's no saying what else would go dark.
+// The parent hub gets the same blast-radius check as any port cycle.
+func (p parentHubCycle) Applicable(env Env, t Target) error {
+	if t.Location.Hub == "" {
+		return errors.New("device's hub port is unknown")
+	}
+	parents := t.parentPorts()
+	if len(parents) == 0 {
+		return fmt.Errorf("hub %s is a root hub, with no port above it", t.Location.Hub)
+	}
+	if _, ok := t.Topology.Hub(t.Location.Hub); !ok {
+		return fmt.Errorf("hub %s isn't in the uhubctl topology, so what else it powers can't be listed", t.Location.Hub)
+	}
+	for _, l := range parents {
+		if err := switchable(env, l); err != nil {
+			return err
+		}
+	}
+	// Everything on the hub going dark is the point, and Execute lists it;
+	// what's checked is that nothing beside the hub does.
+	return checkBlastRadius(env, t, parents, parents)
+}
+
+// Execute logs every other device on the hub, then cycles its upstream
+// port (both halves, for a USB3 hub with a companion).
+func (p parentHubCycle) Execute(ctx context.Context, env Env, t Target) error {
+	hubs := []string{t.Location.Hub}
+	if t.Companion.Hub != "" {
+		hubs = append(hubs, t.Companion.Hub)
+	}
+	others := poweredWith(t.Topology, hubs, t.Location, t.Companion)
+	if len(others) == 0 {
+		log.Printf("reset: powering off hub %s; nothing else is plugged into it", strings.Join(hubs, " and "))
+	} else {
+		log.Printf("reset: powering off hub %s also powers off:\n  %s", strings.Join(hubs, " and "), strings.Join(others, "\n  "))
+	}
+	cyclePorts(ctx, env, t, p.off, t.parentPorts()...)
+	return nil
+}
+
+func (p parentHubCycle) String() string {
+	return fmt.Sprintf("%s, %s off", KindParentHubCycle, p.off)
+}
@@ -0,0 +1,101 @@
+package reset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func loadCapture(t *testing.T, name string) Topology {
+	t.Helper()
+	out, err := os.ReadFile(filepath.Join("testdata", "uhubctl", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo, err := ParseTopology(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topo
+}
+
+func TestUpstreamPort(t *testing.T) {
+	tests := []struct {
+		hub  string
+		want Location
+		ok   bool
+	}{
+		{"2-1.4", Location{Hub: "2-1", Port: "4"}, true},
+		{"20-3.3", Location{Hub: "20-3", Port: "3"}, true},
+		{"2-1", Location{Hub: "2", Port: "1"}, true},
+		{"2", Location{}, false},
+	}
+	for _, tt := range tests {
+		if got, ok := upstreamPort(tt.hub); got != tt.want || ok != tt.ok {
+			t.Errorf("upstreamPort(%q) = %+v, %v; want %+v, %v", tt.hub, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParentHubCycle(t *testing.T) {
+	bin, logFile := fakeUhubctl(t)
+	env := Env{UhubctlPath: bin, PortControl: PortControlUhubctl, SysfsRoot: t.TempDir(), JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+	// uhubctl doesn't list the root hubs; sysfs shows nothing else on
+	// their ports.
+	fakePort(t, env.SysfsRoot, Location{Hub: "4", Port: "1"})
+	fakePort(t, env.SysfsRoot, Location{Hub: "3", Port: "1"})
+	tgt := Target{
+		Name:      "cam",
+		Location:  Location{Hub: "4-1", Port: "2"},
+		Companion: Location{Hub: "3-1", Port: "2"},
+		Topology:  loadCapture(t, "via-vl817-dock"),
+	}
+
+	others := poweredWith(tgt.Topology, []string{"4-1", "3-1"}, tgt.Location, tgt.Companion)
+	want := []string{
+		"4-1 port 3: 0bda:8153 Realtek USB 10/100/1000 LAN 001000001",
+		"3-1 port 1: 046d:c52b Logitech USB Receiver",
+		"3-1 port 4: 2109:8817 VIA Labs, Inc. USB Billboard Device 0000000000000001",
+	}
+	if !slices.Equal(others, want) {
+		t.Errorf("poweredWith = %q, want %q", others, want)
+	}
+
+	s, err := Stage{Kind: KindParentHubCycle, OffTime: time.Millisecond}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Fatalf("Applicable = %v", err)
+	}
+	if err := s.Execute(context.Background(), env, tgt); err != nil {
+		t.Fatalf("Execute = %v", err)
+	}
+	calls := readCalls(t, logFile)
+	wantCalls := []string{"-l 4 -p 1 -a off", "-l 3 -p 1 -a off", "-l 4 -p 1 -a on", "-l 3 -p 1 -a on"}
+	if !slices.Equal(calls, wantCalls) {
+		t.Errorf("uhubctl calls = %q, want %q", calls, wantCalls)
+	}
+	if hubs := tgt.Hubs(); !slices.Equal(hubs, []string{"4-1", "3-1", "4", "3"}) {
+		t.Errorf("Hubs() = %q, want the parents included", hubs)
+	}
+}
+
+func TestParentHubCycleNotApplicable(t *testing.T) {
+	s, _ := Stage{Kind: KindParentHubCycle, OffTime: time.Second}.Strategy()
+	tests := []struct {
+		name string
+		tgt  Target
+	}{
+		{"root hub", Target{Location: Location{Hub: "2", Port: "1"}, Topology: loadCapture(t, "rpi4")}},
+		{"no topology", Target{Location: Location{Hub: "4-1", Port: "2"}}},
+	}
+	for _, tt := range tests {
+		if err := s.Applicable(Env{}, tt.tgt); err == nil {
+			t.Errorf("%s: Applicable = nil, want an error", tt.name)
+		}
+	}
+}
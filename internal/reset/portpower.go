@@ -0,0 +1,155 @@
+package reset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PortControl selects how hub ports are switched on and off.
+type PortControl string
+
+const (
+	// PortControlAuto uses sysfs for ports that have a writable disable
+	// attribute and uhubctl for the rest.
+	PortControlAuto PortControl = "auto"
+	// PortControlUhubctl always uses uhubctl.
+	PortControlUhubctl PortControl = "uhubctl"
+	// PortControlSysfs always uses the port's sysfs disable attribute, so
+	// uhubctl needn't be installed (Linux only).
+	PortControlSysfs PortControl = "sysfs"
+)
+
+// ParsePortControl validates a port control name, as given in config. An
+// empty name selects PortControlAuto.
+func ParsePortControl(s string) (PortControl, error) {
+	switch pc := PortControl(s); pc {
+	case "":
+		return PortControlAuto, nil
+	case PortControlAuto, PortControlUhubctl, PortControlSysfs:
+		return pc, nil
+	default:
+		return "", fmt.Errorf("unknown port control %q (want %s, %s or %s)", s, PortControlAuto, PortControlUhubctl, PortControlSysfs)
+	}
+}
+
+// portSwitch turns one hub port's power on or off, and reads it back.
+type portSwitch interface {
+	power(l Location, on bool) error
+	powered(l Location) (bool, error)
+	String() string
+}
+
+// portSwitch returns what switches l's power under env's PortControl.
+func (env Env) portSwitch(l Location) portSwitch {
+	sysfs := sysfsPorts{root: env.sysfs()}
+	switch env.PortControl {
+	case PortControlSysfs:
+		return sysfs
+	case PortControlUhubctl:
+		return uhubctl{path: env.UhubctlPath}
+	}
+	if sysfs.writable(l) {
+		return sysfs
+	}
+	return uhubctl{path: env.UhubctlPath}
+}
+
+// setPower switches l on or off with whatever env's port control picks.
+func setPower(env Env, l Location, on bool) error {
+	sw := env.portSwitch(l)
+	if err := sw.power(l, on); err != nil {
+		return fmt.Errorf("%s %s %s port %s: %w", sw, onOff(on), l.Hub, l.Port, err)
+	}
+	return nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// uhubctl switches port power by running uhubctl.
+type uhubctl struct {
+	path string
+}
+
+func (u uhubctl) power(l Location, on bool) error {
+	cmd := exec.Command(u.path, "-l", l.Hub, "-p", l.Port, "-a", onOff(on))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// powered scans the tree for l's hub and reads the port's power bit.
+func (u uhubctl) powered(l Location) (bool, error) {
+	topo, err := ScanTopology(u.path)
+	if err != nil {
+		return false, err
+	}
+	hub, ok := topo.Hub(l.Hub)
+	if !ok {
+		return false, fmt.Errorf("hub %s not in uhubctl's tree", l.Hub)
+	}
+	port, ok := hub.Port(l.portNumber())
+	if !ok {
+		return false, fmt.Errorf("hub %s has no port %s", l.Hub, l.Port)
+	}
+	return port.Power, nil
+}
+
+func (uhubctl) String() string { return "uhubctl" }
+
+// sysfsPorts switches port power through the kernel's port disable
+// attribute, e.g. /sys/bus/usb/devices/2-1/2-1:1.0/2-1-port4/disable.
+// Writing 1 disables the port and, on hubs that switch power per port,
+// powers it off; 0 brings it back.
+type sysfsPorts struct {
+	root string
+}
+
+func (s sysfsPorts) attr(l Location) string {
+	return filepath.Join(portDir(s.root, l), "disable")
+}
+
+// writable reports whether l's disable attribute exists and we may write
+// it, which is what auto mode needs to pick sysfs over uhubctl. Opening
+// for writing doesn't write anything.
+func (s sysfsPorts) writable(l Location) bool {
+	f, err := os.OpenFile(s.attr(l), os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func (s sysfsPorts) power(l Location, on bool) error {
+	value := "1"
+	if on {
+		value = "0"
+	}
+	if err := writeAttr(s.attr(l), value); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no port disable attribute at %s", s.attr(l))
+		}
+		return err
+	}
+	return nil
+}
+
+func (s sysfsPorts) powered(l Location) (bool, error) {
+	b, err := os.ReadFile(s.attr(l))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(b)) == "0", nil
+}
+
+func (sysfsPorts) String() string { return "sysfs" }
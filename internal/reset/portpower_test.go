@@ -0,0 +1,85 @@
+package reset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePort creates the sysfs disable attribute of l's port under root.
+func fakePort(t *testing.T, root string, l Location) string {
+	t.Helper()
+	dir := portDir(root, l)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	attr := filepath.Join(dir, "disable")
+	if err := os.WriteFile(attr, []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return attr
+}
+
+func TestCyclePortsViaSysfs(t *testing.T) {
+	root := t.TempDir()
+	port, companion := Location{Hub: "2-1", Port: "4"}, Location{Hub: "1", Port: "4"}
+	attrs := []string{fakePort(t, root, port), fakePort(t, root, companion)}
+	bin, logFile := fakeUhubctl(t)
+	env := Env{UhubctlPath: bin, SysfsRoot: root, JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+
+	// Watch the attributes mid-cycle by cutting the off window short from
+	// a goroutine once both ports read disabled.
+	ctx, cancel := context.WithCancel(context.Background())
+	seenOff := make(chan bool, 1)
+	go func() {
+		defer cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			a, _ := os.ReadFile(attrs[0])
+			b, _ := os.ReadFile(attrs[1])
+			if string(a) == "1" && string(b) == "1" {
+				seenOff <- true
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		seenOff <- false
+	}()
+	cyclePorts(ctx, env, Target{Name: "cam", Location: port, Companion: companion}, time.Minute, port, companion)
+
+	if !<-seenOff {
+		t.Error("ports never read disabled during the off window")
+	}
+	for _, attr := range attrs {
+		if got := readFile(t, attr); got != "0" {
+			t.Errorf("%s = %q after the cycle, want 0", attr, got)
+		}
+	}
+	if calls := readCalls(t, logFile); strings.Join(calls, "") != "" {
+		t.Errorf("auto mode ran uhubctl with sysfs available: %q", calls)
+	}
+}
+
+func TestPortSwitchFallsBackToUhubctl(t *testing.T) {
+	root := t.TempDir()
+	l := Location{Hub: "2-1", Port: "4"}
+	if sw := (Env{SysfsRoot: root}).portSwitch(l); sw.String() != "uhubctl" {
+		t.Errorf("auto with no sysfs port = %s, want uhubctl", sw)
+	}
+	fakePort(t, root, l)
+	if sw := (Env{SysfsRoot: root}).portSwitch(l); sw.String() != "sysfs" {
+		t.Errorf("auto with a sysfs port = %s, want sysfs", sw)
+	}
+	if sw := (Env{SysfsRoot: root, PortControl: PortControlUhubctl}).portSwitch(l); sw.String() != "uhubctl" {
+		t.Errorf("forced uhubctl = %s, want uhubctl", sw)
+	}
+
+	s, _ := Stage{Kind: KindPortCycle, OffTime: time.Second}.Strategy()
+	env := Env{SysfsRoot: t.TempDir(), PortControl: PortControlSysfs}
+	if err := s.Applicable(env, Target{Location: l}); err == nil {
+		t.Error("port cycle with sysfs forced and no disable attribute: Applicable = nil, want an error")
+	}
+}
@@ -1,79 +1,211 @@
 package reset
 
 import (
+	"context"
+	"errors"
 	"log"
-	"os/exec"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/phinze/camlink-fix/internal/health"
 )
 
-// stage defines one escalating reset attempt.
-type stage struct {
-	name     string
-	offTime  time.Duration
-	bothPorts bool
+// Stage defines one escalating reset attempt: a strategy, and how long to
+// give the device afterwards.
+type Stage struct {
+	Name string
+	// Kind selects the Strategy; see Kinds. Empty means KindPortCycle.
+	Kind string
+	// OffTime is how long power-cycling kinds keep the power off.
+	OffTime time.Duration
+	// Settle is how long to let the device re-enumerate before checking.
+	Settle time.Duration
 }
 
-var stages = []stage{
-	{"quick cycle", 2 * time.Second, false},
-	{"full reset", 10 * time.Second, true},
-	{"extended reset", 30 * time.Second, true},
+// DefaultStages is the built-in reset ladder.
+var DefaultStages = []Stage{
+	{Name: "quick cycle", Kind: KindPortCycle, OffTime: 2 * time.Second, Settle: 3 * time.Second},
+	{Name: "full reset", Kind: KindBothPortsCycle, OffTime: 10 * time.Second, Settle: 5 * time.Second},
+	{Name: "extended reset", Kind: KindBothPortsCycle, OffTime: 30 * time.Second, Settle: 5 * time.Second},
 }
 
-// Run executes the escalating reset strategy. Returns true if the camera
-// recovers at any stage.
-func Run(uhubctlPath string, loc Location, companionHub string, healthCfg health.Config) bool {
-	// Remember where the device lives so a killed reset can be healed on the
-	// next startup — once ports are off, uhubctl can't find the device to
-	// locate it again.
-	saveLocation(loc, companionHub)
+// StageOutcome is what one stage of a reset did.
+type StageOutcome struct {
+	Stage string `json:"stage"`
+	Kind  string `json:"kind"`
+	// Skipped says why the stage didn't run, if it didn't.
+	Skipped string `json:"skipped,omitempty"`
+	// Unsafe is set when the stage was skipped for what else it would have
+	// powered off; see UnsafeError.
+	Unsafe bool `json:"unsafe,omitempty"`
+	// Error is why the strategy failed part way, if it did.
+	Error string `json:"error,omitempty"`
+	// Result is the health check after the stage settled.
+	Result *health.Result `json:"result,omitempty"`
+	// Duration covers the strategy and the settle wait.
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the record of one reset, stage by stage.
+type Report struct {
+	Stages []StageOutcome `json:"stages"`
+	// FixedBy names the stage after which the device checked healthy, if
+	// one did.
+	FixedBy string `json:"fixed_by,omitempty"`
+	// Result is the last health check, so a caller can see how the device
+	// failed if no stage helped.
+	Result health.Result `json:"result"`
+}
+
+// Unsafe returns why each stage that was refused as unsafe was, prefixed
+// with the stage's name.
+func (r Report) Unsafe() []string {
+	var reasons []string
+	for _, s := range r.Stages {
+		if s.Unsafe {
+			reasons = append(reasons, s.Stage+": "+strings.TrimPrefix(s.Skipped, "unsafe: "))
+		}
+	}
+	return reasons
+}
+
+// Target is a device to reset and where it sits in the hub tree.
+type Target struct {
+	// Name identifies the device in the saved-location state.
+	Name     string
+	Location Location
+	// Companion is the other half (USB2 or USB3) of the device's port, or
+	// the zero Location if there isn't one; see FindCompanion.
+	Companion Location
+	// Topology is the hub tree the device was found in, if uhubctl could
+	// scan it. A parent-hub cycle needs it to say what else loses power.
+	Topology Topology
+}
+
+// Hubs returns every hub a reset of t may switch ports on, including the
+// ones its hub and companion hang off, for a parent-hub cycle.
+func (t Target) Hubs() []string {
+	var hubs []string
+	for _, l := range append([]Location{t.Location, t.Companion}, t.parentPorts()...) {
+		if l.Hub != "" && !slices.Contains(hubs, l.Hub) {
+			hubs = append(hubs, l.Hub)
+		}
+	}
+	return hubs
+}
+
+// Run works up the ladder of stages, stopping at the first after which the
+// camera checks healthy. Stages whose strategy doesn't apply to t are
+// skipped. Returns a report of what each stage did.
+//
+// Cancelling ctx stops the ladder at the next opportunity — cutting short an
+// off window or settle wait — but never before the ports that were powered
+// off are powered back on.
+//
+// Run doesn't lock anything itself; callers resetting several devices hold
+// the target's hubs in a HubLocks for the duration.
+func Run(ctx context.Context, env Env, t Target, stages []Stage, healthCfg health.Config) Report {
+	recordLocation(env, t)
 
+	var rep Report
 	for _, s := range stages {
-		log.Printf("reset: trying %s (%s off)...", s.name, s.offTime)
+		if ctx.Err() != nil {
+			break
+		}
+		out := StageOutcome{Stage: s.Name, Kind: s.Kind}
+		strategy, err := s.Strategy()
+		if err == nil {
+			out.Kind = strategy.Kind()
+			err = strategy.Applicable(env, t)
+		}
+		if err != nil {
+			log.Printf("reset: skipping %s: %v", s.Name, err)
+			var unsafe *UnsafeError
+			out.Skipped, out.Unsafe = err.Error(), errors.As(err, &unsafe)
+			rep.Stages = append(rep.Stages, out)
+			continue
+		}
 
-		if s.bothPorts && companionHub != "" {
-			cycleBothPorts(uhubctlPath, loc.Hub, companionHub, loc.Port, s.offTime)
-		} else {
-			// Quick cycle using uhubctl's built-in cycle
-			hubctl(uhubctlPath, loc.Hub, loc.Port, "cycle")
+		log.Printf("reset: trying %s (%s)...", s.Name, describe(strategy))
+		start := time.Now()
+		if err := strategy.Execute(ctx, env, t); err != nil {
+			log.Printf("reset: %s failed: %v", s.Name, err)
+			out.Error = err.Error()
 		}
 
 		// Wait for device to settle
-		settleTime := 3 * time.Second
-		if s.bothPorts {
-			settleTime = 5 * time.Second
+		if !sleep(ctx, s.Settle) {
+			out.Duration = time.Since(start)
+			rep.Stages = append(rep.Stages, out)
+			break
 		}
-		time.Sleep(settleTime)
 
-		if health.Check(healthCfg) {
-			log.Printf("reset: camera recovered after %s", s.name)
-			return true
+		res := health.Check(ctx, healthCfg)
+		out.Result, out.Duration = &res, time.Since(start)
+		rep.Stages = append(rep.Stages, out)
+		rep.Result = res
+		if res.Healthy() {
+			log.Printf("reset: camera recovered after %s: %s", s.Name, res)
+			rep.FixedBy = s.Name
+			return rep
 		}
+		log.Printf("reset: still %s after %s", res, s.Name)
 	}
 
+	if ctx.Err() != nil {
+		log.Printf("reset: interrupted, ports are powered back on")
+		return rep
+	}
 	log.Printf("reset: camera still not working after all reset stages")
-	return false
+	return rep
 }
 
-// cycleBothPorts powers the device's USB3 hub port and its USB2 companion off
-// for offTime, then back on. The power-on is deferred so it runs even if the
-// off window is interrupted by a panic — a reset must never leave the ports
-// dark. (SIGKILL can't be caught; that case is covered by Heal at startup.)
-func cycleBothPorts(uhubctlPath, hub, companion, port string, offTime time.Duration) {
+// cyclePorts powers off each of ports (the device's own and, for a
+// both-ports stage, its companion) for offTime, then back on. The
+// power-on is deferred and ignores ctx, so it runs even if the off window is
+// cut short by shutdown or interrupted by a panic — a reset must never leave
+// the ports dark. (SIGKILL can't be caught; that case is covered by the
+// journal, written before the ports go off, and Heal at startup.)
+//
+// This does the off/on itself rather than using uhubctl's cycle action,
+// because a uhubctl killed mid-cycle leaves its port off.
+func cyclePorts(ctx context.Context, env Env, t Target, offTime time.Duration, ports ...Location) {
+	journalOff(env, t, ports)
 	defer func() {
-		hubctl(uhubctlPath, hub, port, "on")
-		hubctl(uhubctlPath, companion, port, "on")
+		restored := true
+		for _, p := range ports {
+			if err := setPower(env, p, true); err != nil {
+				log.Printf("ERROR: reset: %v", err)
+				restored = false
+			}
+		}
+		// A port that didn't come back on stays in the journal, unfinished,
+		// for Heal to try again.
+		if restored {
+			journalRestored(env, t.Name)
+		}
 	}()
 
-	hubctl(uhubctlPath, hub, port, "off")
-	hubctl(uhubctlPath, companion, port, "off")
-	time.Sleep(offTime)
+	// A port that won't switch off just makes the stage useless; the
+	// power-on above still runs for it.
+	for _, p := range ports {
+		if err := setPower(env, p, false); err != nil {
+			log.Printf("reset: %v", err)
+		}
+	}
+	sleep(ctx, offTime)
 }
 
-func hubctl(uhubctlPath, hub, port, action string) {
-	cmd := exec.Command(uhubctlPath, "-l", hub, "-p", port, "-a", action)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("reset: uhubctl %s %s port %s: %v: %s", action, hub, port, err, out)
+// sleep waits for d or until ctx is cancelled, reporting whether the full
+// duration passed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
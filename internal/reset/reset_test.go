@@ -0,0 +1,132 @@
+package reset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+)
+
+// fakeUhubctl writes a uhubctl stand-in that appends each invocation's
+// arguments to a log file, and returns its path and the log's.
+func fakeUhubctl(t *testing.T) (bin, logFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	bin = filepath.Join(dir, "uhubctl")
+	logFile = filepath.Join(dir, "calls.log")
+	script := "#!/bin/sh\necho \"$*\" >> " + logFile + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, logFile
+}
+
+func readCalls(t *testing.T, logFile string) []string {
+	t.Helper()
+	data, err := os.ReadFile(logFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// bareHubs returns an env switching ports with the uhubctl at bin, whose
+// sysfs has hubs 2-1 and 1-1 with nothing plugged in, so the blast-radius
+// check lets their port 4 be cycled without a uhubctl scan, and a temp
+// journal.
+func bareHubs(t *testing.T, bin string) Env {
+	t.Helper()
+	env := Env{UhubctlPath: bin, PortControl: PortControlUhubctl, SysfsRoot: t.TempDir(), JournalPath: filepath.Join(t.TempDir(), "journal.json")}
+	fakePort(t, env.SysfsRoot, Location{Hub: "2-1", Port: "4"})
+	fakePort(t, env.SysfsRoot, Location{Hub: "1-1", Port: "4"})
+	return env
+}
+
+// TestRunCancelledDuringOffWindow is the SIGTERM case: the daemon cancels its
+// context while a reset has the ports powered off. Run must return promptly,
+// and must power every port it switched off back on before it does.
+func TestRunCancelledDuringOffWindow(t *testing.T) {
+	bin, logFile := fakeUhubctl(t)
+
+	stages := []Stage{{Name: "full reset", OffTime: time.Minute, Kind: KindBothPortsCycle, Settle: time.Second}}
+	// Nothing under this sysfs root, so any health check finds no device.
+	healthCfg := health.Config{Backend: health.V4L2, DeviceName: "Cam Link 4K", SysfsRoot: t.TempDir()}
+
+	env := bareHubs(t, bin)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Report)
+	go func() {
+		done <- Run(ctx, env, Target{Name: "cam", Location: Location{Hub: "2-1", Port: "4"}, Companion: Location{Hub: "1-1", Port: "4"}}, stages, healthCfg)
+	}()
+
+	// Wait until both ports are off, then "SIGTERM".
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readCalls(t, logFile)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ports never powered off; calls: %q", readCalls(t, logFile))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case rep := <-done:
+		if rep.Result.Healthy() || rep.FixedBy != "" {
+			t.Errorf("interrupted reset reported healthy: %+v", rep)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+
+	want := []string{
+		"-l 2-1 -p 4 -a off",
+		"-l 1-1 -p 4 -a off",
+		"-l 2-1 -p 4 -a on",
+		"-l 1-1 -p 4 -a on",
+	}
+	got := readCalls(t, logFile)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("uhubctl calls:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunStopsAtHealthyStage(t *testing.T) {
+	bin, logFile := fakeUhubctl(t)
+
+	stages := []Stage{
+		{Name: "quick cycle", OffTime: time.Millisecond, Kind: KindPortCycle},
+		{Name: "full reset", OffTime: time.Millisecond, Kind: KindBothPortsCycle},
+	}
+	healthCfg := health.Config{Backend: health.V4L2, DeviceName: "Cam Link 4K", SysfsRoot: t.TempDir()}
+
+	env := bareHubs(t, bin)
+	rep := Run(context.Background(), env, Target{Name: "cam", Location: Location{Hub: "2-1", Port: "4"}, Companion: Location{Hub: "1-1", Port: "4"}}, stages, healthCfg)
+	if rep.Result.State != health.StateAbsent {
+		t.Errorf("State = %s, want absent", rep.Result.State)
+	}
+	if len(rep.Stages) != 2 || rep.FixedBy != "" || rep.Stages[1].Kind != KindBothPortsCycle || rep.Stages[1].Result == nil {
+		t.Errorf("report = %+v", rep)
+	}
+
+	// Camera never comes back, so both stages run; the quick cycle touches
+	// only the USB3 port.
+	want := []string{
+		"-l 2-1 -p 4 -a off",
+		"-l 2-1 -p 4 -a on",
+		"-l 2-1 -p 4 -a off",
+		"-l 1-1 -p 4 -a off",
+		"-l 2-1 -p 4 -a on",
+		"-l 1-1 -p 4 -a on",
+	}
+	if got := readCalls(t, logFile); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("uhubctl calls:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if loc, ok := LastLocation(env, "cam"); !ok || loc.Hub != "2-1" || loc.Port != "4" || loc.Companion != "1-1" {
+		t.Errorf("LastLocation() = %+v, %v", loc, ok)
+	}
+}
@@ -0,0 +1,166 @@
+package reset
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UnsafeError is why a power stage was refused: switching its ports would
+// cut power to more than the device, or the hub says it can't switch them
+// at all. Setting Env.AllowUnsafe lets such stages run anyway.
+type UnsafeError struct {
+	Reason string
+}
+
+func (e *UnsafeError) Error() string { return "unsafe: " + e.Reason }
+
+// checkBlastRadius looks in t's topology at what switching off ports would
+// power down. mine are the ports whose devices are meant to go dark (the
+// camera's own, or for a parent-hub cycle the hub's); a device anywhere
+// else that shares power with them makes the stage unsafe, as does a hub
+// that can't switch power.
+//
+// A hub the topology doesn't have (with port control set to sysfs, or when
+// the camera was found through sysfs) is read from sysfs instead. sysfs
+// doesn't say how a hub switches power, so every other device on it counts
+// as sharing the camera's. A hub that's in neither can't be judged at all,
+// and is refused too.
+func checkBlastRadius(env Env, t Target, ports, mine []Location) error {
+	cam, known := t.Topology.deviceAt(t.Location)
+	if !known {
+		if d := sysfsDevice(usbDeviceDir(env, t)); d != nil {
+			cam, known = *d, true
+		}
+	}
+	own := func(l Location, d *Device) bool {
+		if slices.Contains(mine, l) {
+			return true
+		}
+		// The camera itself can show up on the companion port, when it's
+		// enumerated on the other USB speed. Only there, though: another
+		// camera of the same model elsewhere on a ganged hub is still
+		// someone else's.
+		return known && l == t.Companion && d.ID == cam.ID
+	}
+
+	var reasons []string
+	for _, p := range ports {
+		hub, ok := t.Topology.Hub(p.Hub)
+		if !ok {
+			hub, ok = sysfsHub(env, p.Hub)
+		}
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("hub %s is in neither the uhubctl scan nor sysfs, so there's no telling what else port %s powers", p.Hub, p.Port))
+			continue
+		}
+		var shared []Port
+		switch hub.PowerSwitching {
+		case PowerNone:
+			reasons = append(reasons, fmt.Sprintf("hub %s can't switch port power (%s)", hub.Location, hub.PowerSwitching))
+			continue
+		case PowerGanged, powerUnknown:
+			// One switch for every port, or maybe so.
+			shared = hub.Ports
+		default:
+			n, _ := strconv.Atoi(p.Port)
+			if port, ok := hub.Port(n); ok {
+				shared = []Port{port}
+			}
+		}
+		var others []string
+		for _, sp := range shared {
+			l := Location{Hub: hub.Location, Port: strconv.Itoa(sp.Number)}
+			if sp.Device != nil && !own(l, sp.Device) {
+				others = append(others, strings.TrimSpace(fmt.Sprintf("%s (port %d)", sp.Device.describe(), sp.Number)))
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		switch hub.PowerSwitching {
+		case PowerGanged:
+			reasons = append(reasons, fmt.Sprintf("hub %s switches all its ports together (%s), so port %s off also powers off %s",
+				hub.Location, hub.PowerSwitching, p.Port, strings.Join(others, ", ")))
+		case powerUnknown:
+			reasons = append(reasons, fmt.Sprintf("hub %s isn't in the uhubctl scan, so whether it switches its ports together is unknown, and port %s off may also power off %s",
+				hub.Location, p.Port, strings.Join(others, ", ")))
+		default:
+			reasons = append(reasons, fmt.Sprintf("port %s of hub %s also powers %s", p.Port, hub.Location, strings.Join(others, ", ")))
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	err := &UnsafeError{Reason: strings.Join(reasons, "; ")}
+	if env.AllowUnsafe {
+		log.Printf("reset: %v; going ahead since unsafe resets are allowed", err)
+		return nil
+	}
+	return err
+}
+
+// powerUnknown is the PowerSwitching of a hub read from sysfs, which
+// doesn't say.
+const powerUnknown PowerSwitching = ""
+
+// sysfsHub reads hub's ports, and what's plugged into each, from sysfs, for
+// a hub the uhubctl scan doesn't have. Its power switching is left unknown.
+// Returns false if sysfs doesn't have the hub either.
+func sysfsHub(env Env, hub string) (Hub, bool) {
+	dirs, _ := filepath.Glob(portDir(env.sysfs(), Location{Hub: hub, Port: "*"}))
+	if len(dirs) == 0 {
+		return Hub{}, false
+	}
+	h := Hub{Location: hub, PowerSwitching: powerUnknown}
+	for _, dir := range dirs {
+		_, num, _ := strings.Cut(filepath.Base(dir), "-port")
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			continue
+		}
+		port := Port{Number: n}
+		// A port with something plugged in links to its device directory.
+		if dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+			port.Device = sysfsDevice(dev)
+		}
+		h.Ports = append(h.Ports, port)
+	}
+	slices.SortFunc(h.Ports, func(a, b Port) int { return a.Number - b.Number })
+	h.NumPorts = len(h.Ports)
+	return h, true
+}
+
+// sysfsDevice reads the USB device in sysfs directory dir the way uhubctl
+// would describe it, or returns nil if there isn't one.
+func sysfsDevice(dir string) *Device {
+	vid, err1 := strconv.ParseUint(readSysfsAttr(dir, "idVendor"), 16, 16)
+	pid, err2 := strconv.ParseUint(readSysfsAttr(dir, "idProduct"), 16, 16)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	desc := strings.Join(strings.Fields(readSysfsAttr(dir, "manufacturer")+" "+readSysfsAttr(dir, "product")+" "+readSysfsAttr(dir, "serial")), " ")
+	return &Device{ID: USBID{Vendor: uint16(vid), Product: uint16(pid)}, Description: desc}
+}
+
+// describe names d by its ID and descriptor strings.
+func (d Device) describe() string {
+	return strings.TrimSpace(d.ID.String() + " " + d.Description)
+}
+
+// deviceAt returns what's plugged into l, if the topology shows anything.
+func (t Topology) deviceAt(l Location) (Device, bool) {
+	hub, ok := t.Hub(l.Hub)
+	if !ok {
+		return Device{}, false
+	}
+	n, _ := strconv.Atoi(l.Port)
+	p, ok := hub.Port(n)
+	if !ok || p.Device == nil {
+		return Device{}, false
+	}
+	return *p.Device, true
+}
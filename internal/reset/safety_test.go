@@ -0,0 +1,150 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+)
+
+func TestBlastRadius(t *testing.T) {
+	tests := []struct {
+		capture   string
+		loc       Location
+		companion Location
+		kind      string
+		want      string // in the UnsafeError; empty for safe
+	}{
+		// Nothing else is on the ganged USB3 half, so cycling it is fine...
+		{"genesys-gl3523-ganged", Location{Hub: "2-3", Port: "3"}, Location{Hub: "1-3", Port: "3"}, KindPortCycle, ""},
+		// ...but the USB2 half also has the keyboard.
+		{"genesys-gl3523-ganged", Location{Hub: "2-3", Port: "3"}, Location{Hub: "1-3", Port: "3"}, KindBothPortsCycle, "413c:2113 Dell KB216 Wired Keyboard (port 4)"},
+		{"realtek-rts5411-nops", Location{Hub: "2-2", Port: "1"}, Location{Hub: "1-2", Port: "1"}, KindPortCycle, "hub 2-2 can't switch port power"},
+		{"via-vl817-dock", Location{Hub: "4-1", Port: "2"}, Location{Hub: "3-1", Port: "2"}, KindBothPortsCycle, ""},
+		// Port 4 of the USB2 half has the dock's billboard device, not the camera.
+		{"via-vl817-dock", Location{Hub: "4-1", Port: "2"}, Location{Hub: "3-1", Port: "4"}, KindBothPortsCycle, "port 4 of hub 3-1 also powers 2109:8817"},
+	}
+	for _, tt := range tests {
+		tgt := Target{Location: tt.loc, Companion: tt.companion, Topology: loadCapture(t, tt.capture)}
+		s, err := Stage{Kind: tt.kind, OffTime: time.Second}.Strategy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := Env{PortControl: PortControlUhubctl, SysfsRoot: t.TempDir()}
+		err = s.Applicable(env, tgt)
+		var unsafe *UnsafeError
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s %s: Applicable = %v, want nil", tt.capture, tt.kind, err)
+		case tt.want != "" && (!errors.As(err, &unsafe) || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s %s: Applicable = %v, want an UnsafeError mentioning %q", tt.capture, tt.kind, err, tt.want)
+		case tt.want != "":
+			env.AllowUnsafe = true
+			if err := s.Applicable(env, tgt); err != nil {
+				t.Errorf("%s %s: Applicable with AllowUnsafe = %v, want nil", tt.capture, tt.kind, err)
+			}
+		}
+	}
+}
+
+// TestBlastRadiusSecondCamLink has two Cam Links on one ganged hub. Only
+// the camera's own enumeration on the companion port is exempt; the other
+// one goes dark too, so cycling the port is unsafe.
+func TestBlastRadiusSecondCamLink(t *testing.T) {
+	topo, err := ParseTopology([]byte(`Current status for hub 2-3 [05e3:0626 GenesysLogic USB3.1 Hub, USB 3.10, 4 ports, ganged]
+  Port 1: 0203 power 5gbps U0 enable connect [0fd9:007b Elgato Cam Link 4K 0004BB21D002]
+  Port 2: 02a0 power 5gbps Rx.Detect
+  Port 3: 0203 power 5gbps U0 enable connect [0fd9:007b Elgato Cam Link 4K 0004BB21C790]
+  Port 4: 02a0 power 5gbps Rx.Detect
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt := Target{Location: Location{Hub: "2-3", Port: "3"}, Companion: Location{Hub: "1-3", Port: "3"}, Topology: topo}
+	s, err := Stage{Kind: KindPortCycle, OffTime: time.Second}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Applicable(Env{PortControl: PortControlUhubctl, SysfsRoot: t.TempDir()}, tgt)
+	var unsafe *UnsafeError
+	if !errors.As(err, &unsafe) || !strings.Contains(err.Error(), "0fd9:007b Elgato Cam Link 4K 0004BB21D002 (port 1)") {
+		t.Errorf("Applicable = %v, want an UnsafeError naming the other Cam Link", err)
+	}
+}
+
+// TestBlastRadiusFromSysfs is port control set to sysfs: there's no uhubctl
+// scan, so what else is on the hub comes from sysfs.
+func TestBlastRadiusFromSysfs(t *testing.T) {
+	root, tgt := fakeSysfs(t)
+	env := Env{PortControl: PortControlSysfs, SysfsRoot: root}
+	devices := filepath.Join(root, "bus", "usb", "devices")
+	plug := func(l Location) {
+		t.Helper()
+		fakePort(t, root, l)
+		if err := os.Symlink(filepath.Join(devices, l.deviceName()), filepath.Join(portDir(root, l), "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Stage{Kind: KindPortCycle, OffTime: time.Second}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The camera alone on hub 4-1.
+	plug(tgt.Location)
+	fakePort(t, root, Location{Hub: "4-1", Port: "1"})
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Errorf("camera alone on its hub: Applicable = %v, want nil", err)
+	}
+
+	// A keyboard joins it; sysfs can't say whether the hub is ganged.
+	kbd := Location{Hub: "4-1", Port: "3"}
+	if err := os.MkdirAll(filepath.Join(devices, kbd.deviceName()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, val := range map[string]string{"idVendor": "413c", "idProduct": "2113", "product": "Dell KB216 Wired Keyboard"} {
+		if err := os.WriteFile(filepath.Join(devices, kbd.deviceName(), name), []byte(val+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plug(kbd)
+	err = s.Applicable(env, tgt)
+	var unsafe *UnsafeError
+	if !errors.As(err, &unsafe) || !strings.Contains(err.Error(), "may also power off 413c:2113 Dell KB216 Wired Keyboard (port 3)") {
+		t.Errorf("keyboard on the hub: Applicable = %v, want an UnsafeError naming it", err)
+	}
+	env.AllowUnsafe = true
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Errorf("keyboard on the hub with AllowUnsafe: Applicable = %v, want nil", err)
+	}
+
+	// A hub in neither uhubctl's scan nor sysfs can't be judged.
+	env = Env{PortControl: PortControlUhubctl, SysfsRoot: t.TempDir()}
+	if err := s.Applicable(env, tgt); !errors.As(err, &unsafe) || !strings.Contains(err.Error(), "no telling") {
+		t.Errorf("unknown hub: Applicable = %v, want an UnsafeError", err)
+	}
+}
+
+func TestRunRecordsUnsafeStages(t *testing.T) {
+	bin, logFile := fakeUhubctl(t)
+	healthCfg := health.Config{Backend: health.V4L2, DeviceName: "Cam Link 4K", SysfsRoot: t.TempDir()}
+	tgt := Target{
+		Name:     "cam",
+		Location: Location{Hub: "2-2", Port: "1"},
+		Topology: loadCapture(t, "realtek-rts5411-nops"),
+	}
+	stages := []Stage{{Name: "quick cycle", Kind: KindPortCycle, OffTime: time.Millisecond}}
+	rep := Run(context.Background(), Env{UhubctlPath: bin, PortControl: PortControlUhubctl, JournalPath: filepath.Join(t.TempDir(), "journal.json")}, tgt, stages, healthCfg)
+
+	if unsafe := rep.Unsafe(); len(unsafe) != 1 || !strings.HasPrefix(unsafe[0], "quick cycle: hub 2-2 can't switch port power") {
+		t.Errorf("Unsafe() = %q, want the quick cycle refused", unsafe)
+	}
+	if calls := readCalls(t, logFile); strings.Join(calls, "") != "" {
+		t.Errorf("unsafe stage ran uhubctl: %q", calls)
+	}
+}
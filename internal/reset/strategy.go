@@ -0,0 +1,167 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Strategy is one way of shaking a wedged device loose: a power cycle, a
+// bus reset, a driver rebind. A Stage pairs one with how long to let the
+// device settle afterwards.
+type Strategy interface {
+	// Kind names the strategy, as stages select it in config.
+	Kind() string
+	// Applicable returns why the strategy can't act on t, or nil if it
+	// can. A stage that isn't applicable is skipped, not failed.
+	Applicable(env Env, t Target) error
+	// Execute performs the strategy on t. Whatever it takes away (port
+	// power, a driver binding) it must give back before returning, even
+	// when ctx is cancelled part way.
+	Execute(ctx context.Context, env Env, t Target) error
+}
+
+// Env is what strategies need from the outside world.
+type Env struct {
+	// UhubctlPath is the uhubctl binary used for port power.
+	UhubctlPath string
+	// PortControl selects between uhubctl and sysfs for port power. Empty
+	// means PortControlAuto.
+	PortControl PortControl
+	// SysfsRoot is where sysfs-based strategies and port control look for
+	// USB devices, ports and drivers. Empty means /sys.
+	SysfsRoot string
+	// DeviceResetter issues usbfs device resets. Nil means the
+	// USBDEVFS_RESET ioctl.
+	DeviceResetter DeviceResetter
+	// JournalPath is the reset journal; see Heal. Empty means the
+	// per-user default.
+	JournalPath string
+	// AllowUnsafe lets power stages run even when the topology shows
+	// they'd cut power to other devices, or the hub reports it can't
+	// switch port power; see UnsafeError.
+	AllowUnsafe bool
+}
+
+// Strategy kinds.
+const (
+	// KindPortCycle powers the device's port off and on again.
+	KindPortCycle = "port-cycle"
+	// KindBothPortsCycle powers the device's port and its companion (the
+	// other USB speed's half of the same physical port) off and on
+	// together. Without a companion it cycles the one port.
+	KindBothPortsCycle = "both-ports-cycle"
+)
+
+// ParamError reports a stage setting that doesn't suit its strategy kind.
+type ParamError struct {
+	// Param is the setting, e.g. "off".
+	Param string
+	Msg   string
+}
+
+func (e *ParamError) Error() string { return e.Param + ": " + e.Msg }
+
+// strategies builds each kind of Strategy from a configured stage.
+var strategies = map[string]func(s Stage) (Strategy, error){
+	KindPortCycle: func(s Stage) (Strategy, error) {
+		if s.OffTime <= 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("must be positive, got %s", s.OffTime)}
+		}
+		return portCycle{off: s.OffTime}, nil
+	},
+	KindBothPortsCycle: func(s Stage) (Strategy, error) {
+		if s.OffTime <= 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("must be positive, got %s", s.OffTime)}
+		}
+		return portCycle{off: s.OffTime, bothPorts: true}, nil
+	},
+}
+
+// Kinds lists the strategy kinds a stage can use.
+func Kinds() []string {
+	kinds := make([]string, 0, len(strategies))
+	for k := range strategies {
+		kinds = append(kinds, k)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// Strategy builds the Strategy s describes, or says which of its settings
+// is wrong for its kind. An empty Kind is KindPortCycle.
+func (s Stage) Strategy() (Strategy, error) {
+	kind := s.Kind
+	if kind == "" {
+		kind = KindPortCycle
+	}
+	build, ok := strategies[kind]
+	if !ok {
+		return nil, &ParamError{"kind", fmt.Sprintf("unknown kind %q (want one of %s)", s.Kind, strings.Join(Kinds(), ", "))}
+	}
+	return build(s)
+}
+
+// describe is a strategy's String, if it has one, or its kind.
+func describe(st Strategy) string {
+	if s, ok := st.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return st.Kind()
+}
+
+// portCycle switches port power with uhubctl.
+type portCycle struct {
+	off       time.Duration
+	bothPorts bool
+}
+
+func (p portCycle) Kind() string {
+	if p.bothPorts {
+		return KindBothPortsCycle
+	}
+	return KindPortCycle
+}
+
+func (p portCycle) Applicable(env Env, t Target) error {
+	if t.Location.Hub == "" {
+		return errors.New("device's hub port is unknown")
+	}
+	ports := p.ports(t)
+	for _, l := range ports {
+		if err := switchable(env, l); err != nil {
+			return err
+		}
+	}
+	return checkBlastRadius(env, t, ports, []Location{t.Location})
+}
+
+// ports returns the ports p switches for t.
+func (p portCycle) ports(t Target) []Location {
+	if p.bothPorts && t.Companion.Hub != "" {
+		return []Location{t.Location, t.Companion}
+	}
+	return []Location{t.Location}
+}
+
+// switchable reports why l's power can't be switched under env, if it
+// can't. Only forcing sysfs rules a port out up front; uhubctl's failures
+// show up when it runs.
+func switchable(env Env, l Location) error {
+	if env.PortControl == PortControlSysfs && !(sysfsPorts{root: env.sysfs()}).writable(l) {
+		return fmt.Errorf("port %s of hub %s has no writable sysfs disable attribute", l.Port, l.Hub)
+	}
+	return nil
+}
+
+func (p portCycle) Execute(ctx context.Context, env Env, t Target) error {
+	cyclePorts(ctx, env, t, p.off, p.ports(t)...)
+	return nil
+}
+
+func (p portCycle) String() string {
+	return fmt.Sprintf("%s, %s off", p.Kind(), p.off)
+}
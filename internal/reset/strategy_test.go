@@ -0,0 +1,57 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/health"
+)
+
+func TestStageStrategy(t *testing.T) {
+	tests := []struct {
+		stage     Stage
+		kind      string
+		wantParam string
+	}{
+		{Stage{Name: "legacy", OffTime: time.Second}, KindPortCycle, ""},
+		{Stage{Name: "both", Kind: KindBothPortsCycle, OffTime: time.Second}, KindBothPortsCycle, ""},
+		{Stage{Name: "no off", Kind: KindPortCycle}, "", "off"},
+		{Stage{Name: "typo", Kind: "port-cylce", OffTime: time.Second}, "", "kind"},
+	}
+	for _, tt := range tests {
+		s, err := tt.stage.Strategy()
+		if tt.wantParam != "" {
+			var pe *ParamError
+			if !errors.As(err, &pe) || pe.Param != tt.wantParam {
+				t.Errorf("%s: err = %v, want a %s ParamError", tt.stage.Name, err, tt.wantParam)
+			}
+			continue
+		}
+		if err != nil || s.Kind() != tt.kind {
+			t.Errorf("%s: Strategy() = %v, %v; want kind %s", tt.stage.Name, s, err, tt.kind)
+		}
+	}
+}
+
+func TestRunSkipsInapplicableStages(t *testing.T) {
+	bin, logFile := fakeUhubctl(t)
+	healthCfg := health.Config{Backend: health.V4L2, DeviceName: "Cam Link 4K", SysfsRoot: t.TempDir()}
+
+	// No hub location, so a port cycle has nothing to act on.
+	stages := []Stage{{Name: "quick cycle", Kind: KindPortCycle, OffTime: time.Millisecond}}
+	rep := Run(context.Background(), Env{UhubctlPath: bin, PortControl: PortControlUhubctl, JournalPath: filepath.Join(t.TempDir(), "journal.json")}, Target{Name: "cam"}, stages, healthCfg)
+
+	if len(rep.Stages) != 1 || rep.Stages[0].Skipped == "" || rep.Stages[0].Result != nil {
+		t.Errorf("report = %+v, want the one stage skipped", rep)
+	}
+	if rep.Result.State != health.StateUnknown {
+		t.Errorf("Result = %s, want unknown with no stage run", rep.Result)
+	}
+	if calls := readCalls(t, logFile); strings.Join(calls, "") != "" {
+		t.Errorf("skipped stage ran uhubctl: %q", calls)
+	}
+}
@@ -0,0 +1,236 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// Strategy kinds that work through sysfs rather than port power, for hubs
+// that can't switch it. Linux only.
+const (
+	// KindRebind unbinds the device's interfaces from their drivers
+	// (uvcvideo, snd-usb-audio) and binds them again.
+	KindRebind = "rebind"
+	// KindReauthorize de-authorizes the device and authorizes it again,
+	// which makes the kernel drop it and enumerate it afresh.
+	KindReauthorize = "reauthorize"
+)
+
+// rebindDrivers are the drivers KindRebind cycles. A capture device's video
+// interfaces belong to uvcvideo and its audio ones to snd-usb-audio.
+var rebindDrivers = []string{"uvcvideo", "snd-usb-audio"}
+
+func init() {
+	strategies[KindRebind] = func(s Stage) (Strategy, error) {
+		if s.OffTime < 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("must not be negative, got %s", s.OffTime)}
+		}
+		return rebind{pause: s.OffTime}, nil
+	}
+	strategies[KindReauthorize] = func(s Stage) (Strategy, error) {
+		if s.OffTime < 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("must not be negative, got %s", s.OffTime)}
+		}
+		return reauthorize{pause: s.OffTime}, nil
+	}
+}
+
+// sysfs returns env's sysfs root, defaulting to /sys.
+func (env Env) sysfs() string {
+	if env.SysfsRoot == "" {
+		return "/sys"
+	}
+	return env.SysfsRoot
+}
+
+// deviceName is the kernel's name for the device at l, e.g. "4-1.2" for
+// port 2 of hub 4-1, or "2-1" for port 1 of root hub 2. On Linux uhubctl
+// names hubs the same way sysfs does.
+func (l Location) deviceName() string {
+	if !strings.Contains(l.Hub, "-") {
+		return l.Hub + "-" + l.Port
+	}
+	return l.Hub + "." + l.Port
+}
+
+// usbDeviceDir is t's device directory under /sys/bus/usb/devices.
+func usbDeviceDir(env Env, t Target) string {
+	return filepath.Join(env.sysfs(), "bus", "usb", "devices", t.Location.deviceName())
+}
+
+// boundInterface is one of a device's interfaces and the driver it's bound
+// to.
+type boundInterface struct {
+	name, driver string
+}
+
+// boundInterfaces lists t's interfaces bound to one of rebindDrivers.
+func boundInterfaces(env Env, t Target) ([]boundInterface, error) {
+	dev := usbDeviceDir(env, t)
+	ifaces, err := filepath.Glob(filepath.Join(dev, t.Location.deviceName()+":*"))
+	if err != nil {
+		return nil, err
+	}
+	var bound []boundInterface
+	for _, iface := range ifaces {
+		link, err := os.Readlink(filepath.Join(iface, "driver"))
+		if err != nil {
+			continue
+		}
+		if driver := filepath.Base(link); slices.Contains(rebindDrivers, driver) {
+			bound = append(bound, boundInterface{name: filepath.Base(iface), driver: driver})
+		}
+	}
+	return bound, nil
+}
+
+// writeAttr writes value to a sysfs attribute, as "echo value > path" would.
+func writeAttr(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0o200)
+}
+
+// rebind unbinds a device's interfaces from their drivers and binds them
+// again, which reinitialises the driver's state for the device without
+// touching power or the bus.
+type rebind struct {
+	pause time.Duration
+}
+
+func (rebind) Kind() string { return KindRebind }
+
+func (rebind) Applicable(env Env, t Target) error {
+	if t.Location.Hub == "" {
+		return errors.New("device's hub port is unknown")
+	}
+	bound, err := boundInterfaces(env, t)
+	if err != nil {
+		return err
+	}
+	if len(bound) == 0 {
+		return fmt.Errorf("no interface of %s is bound to %s", t.Location.deviceName(), strings.Join(rebindDrivers, " or "))
+	}
+	return nil
+}
+
+// Execute unbinds every bound interface, waits, and binds them back. The
+// binds are deferred and ignore ctx, like a port cycle's power-on.
+func (r rebind) Execute(ctx context.Context, env Env, t Target) error {
+	bound, err := boundInterfaces(env, t)
+	if err != nil {
+		return err
+	}
+	drivers := filepath.Join(env.sysfs(), "bus", "usb", "drivers")
+
+	var errs []error
+	var unbound []boundInterface
+	defer func() {
+		for _, b := range unbound {
+			if err := writeAttr(filepath.Join(drivers, b.driver, "bind"), b.name); err != nil {
+				log.Printf("reset: binding %s to %s: %v", b.name, b.driver, err)
+			}
+		}
+	}()
+	for _, b := range bound {
+		if err := writeAttr(filepath.Join(drivers, b.driver, "unbind"), b.name); err != nil {
+			errs = append(errs, fmt.Errorf("unbinding %s from %s: %w", b.name, b.driver, err))
+			continue
+		}
+		unbound = append(unbound, b)
+	}
+	sleep(ctx, r.pause)
+	return errors.Join(errs...)
+}
+
+func (r rebind) String() string {
+	return fmt.Sprintf("%s of %s", KindRebind, strings.Join(rebindDrivers, ", "))
+}
+
+// reauthorize flips the device's authorized attribute off and on. With it
+// off the kernel disconnects the device as if it had been unplugged; back
+// on, it enumerates it again from scratch.
+type reauthorize struct {
+	pause time.Duration
+}
+
+func (reauthorize) Kind() string { return KindReauthorize }
+
+func (reauthorize) Applicable(env Env, t Target) error {
+	if t.Location.Hub == "" {
+		return errors.New("device's hub port is unknown")
+	}
+	if _, err := os.Stat(filepath.Join(usbDeviceDir(env, t), "authorized")); err != nil {
+		return fmt.Errorf("no authorized attribute for %s: %w", t.Location.deviceName(), err)
+	}
+	return nil
+}
+
+// Execute de-authorizes the device, waits, and authorizes it again. The
+// re-authorize is deferred and ignores ctx.
+func (r reauthorize) Execute(ctx context.Context, env Env, t Target) error {
+	attr := filepath.Join(usbDeviceDir(env, t), "authorized")
+	if err := writeAttr(attr, "0"); err != nil {
+		return fmt.Errorf("de-authorizing %s: %w", t.Location.deviceName(), err)
+	}
+	defer func() {
+		if err := writeAttr(attr, "1"); err != nil {
+			log.Printf("reset: re-authorizing %s: %v", t.Location.deviceName(), err)
+		}
+	}()
+	sleep(ctx, r.pause)
+	return nil
+}
+
+func (r reauthorize) String() string {
+	return fmt.Sprintf("%s, %s de-authorized", KindReauthorize, r.pause)
+}
+
+// LocateSysfs finds the device sel picks out by scanning
+// /sys/bus/usb/devices under root (empty means /sys). It's the fallback
+// for when uhubctl can't: uhubctl only lists hubs that can switch port
+// power, but a rebind or reauthorize doesn't need that.
+func LocateSysfs(root string, sel device.Selector) (Location, error) {
+	env := Env{SysfsRoot: root}
+	dirs, err := filepath.Glob(filepath.Join(env.sysfs(), "bus", "usb", "devices", "*"))
+	if err != nil {
+		return Location{}, err
+	}
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		// Skip interfaces ("4-1.2:1.0") and root hubs ("usb2").
+		if strings.Contains(name, ":") || !strings.Contains(name, "-") {
+			continue
+		}
+		vid, err1 := strconv.ParseUint(readSysfsAttr(dir, "idVendor"), 16, 16)
+		pid, err2 := strconv.ParseUint(readSysfsAttr(dir, "idProduct"), 16, 16)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		desc := strings.TrimSpace(readSysfsAttr(dir, "manufacturer") + " " + readSysfsAttr(dir, "product"))
+		if !sel.Matches(uint16(vid), uint16(pid), readSysfsAttr(dir, "serial"), desc) {
+			continue
+		}
+		// The last separator splits the parent hub from the port: "4-1.2"
+		// is port 2 of hub 4-1, and "2-1" port 1 of root hub 2.
+		i := strings.LastIndexAny(name, ".-")
+		return Location{Hub: name[:i], Port: name[i+1:]}, nil
+	}
+	return Location{}, fmt.Errorf("device %s not found in sysfs", sel)
+}
+
+func readSysfsAttr(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,134 @@
+package reset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// fakeSysfs lays out a Cam Link at 4-1.2 under a temp sysfs root, with its
+// video interface bound to uvcvideo and its audio one to snd-usb-audio.
+func fakeSysfs(t *testing.T) (root string, tgt Target) {
+	t.Helper()
+	root = t.TempDir()
+	devices := filepath.Join(root, "bus", "usb", "devices")
+	drivers := filepath.Join(root, "bus", "usb", "drivers")
+	dev := filepath.Join(devices, "4-1.2")
+
+	files := map[string]string{
+		"idVendor":     "0fd9",
+		"idProduct":    "007b",
+		"manufacturer": "Elgato",
+		"product":      "Cam Link 4K",
+		"serial":       "0004BA5E",
+		"authorized":   "1",
+		"busnum":       "4",
+		"devnum":       "7",
+	}
+	if err := os.MkdirAll(dev, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, val := range files {
+		if err := os.WriteFile(filepath.Join(dev, name), []byte(val+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for iface, driver := range map[string]string{"4-1.2:1.0": "uvcvideo", "4-1.2:1.2": "snd-usb-audio", "4-1.2:1.4": "usbhid"} {
+		if err := os.MkdirAll(filepath.Join(dev, iface), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(drivers, driver), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(drivers, driver), filepath.Join(dev, iface, "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, Target{Name: "cam", Location: Location{Hub: "4-1", Port: "2"}}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRebind(t *testing.T) {
+	root, tgt := fakeSysfs(t)
+	env := Env{SysfsRoot: root}
+	s, err := Stage{Kind: KindRebind}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Fatalf("Applicable = %v", err)
+	}
+	if err := s.Execute(context.Background(), env, tgt); err != nil {
+		t.Fatalf("Execute = %v", err)
+	}
+
+	drivers := filepath.Join(root, "bus", "usb", "drivers")
+	for driver, iface := range map[string]string{"uvcvideo": "4-1.2:1.0", "snd-usb-audio": "4-1.2:1.2"} {
+		for _, op := range []string{"unbind", "bind"} {
+			if got := readFile(t, filepath.Join(drivers, driver, op)); got != iface {
+				t.Errorf("%s/%s = %q, want %q", driver, op, got, iface)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(drivers, "usbhid", "unbind")); err == nil {
+		t.Error("rebind touched usbhid")
+	}
+
+	other := Target{Name: "other", Location: Location{Hub: "4-1", Port: "3"}}
+	if err := s.Applicable(env, other); err == nil {
+		t.Error("Applicable on a device with no bound interfaces = nil, want an error")
+	}
+}
+
+func TestReauthorize(t *testing.T) {
+	root, tgt := fakeSysfs(t)
+	env := Env{SysfsRoot: root}
+	s, err := Stage{Kind: KindReauthorize}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Fatalf("Applicable = %v", err)
+	}
+
+	// A cancelled ctx cuts the pause short but must not skip re-authorizing.
+	attr := filepath.Join(root, "bus", "usb", "devices", "4-1.2", "authorized")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Execute(ctx, env, tgt); err != nil {
+		t.Fatalf("Execute = %v", err)
+	}
+	if got := readFile(t, attr); got != "1" {
+		t.Errorf("authorized = %q after Execute, want 1", got)
+	}
+
+	if err := s.Applicable(Env{SysfsRoot: t.TempDir()}, tgt); err == nil {
+		t.Error("Applicable with no authorized attribute = nil, want an error")
+	}
+}
+
+func TestLocateSysfs(t *testing.T) {
+	root, tgt := fakeSysfs(t)
+	sel := device.CamLink
+	sel.Serial = "0004BA5E"
+	loc, err := LocateSysfs(root, sel)
+	if err != nil || loc != tgt.Location {
+		t.Errorf("LocateSysfs = %+v, %v; want %+v", loc, err, tgt.Location)
+	}
+
+	sel.Serial = "NOPE"
+	if _, err := LocateSysfs(root, sel); err == nil {
+		t.Error("LocateSysfs with the wrong serial = nil error, want not found")
+	}
+}
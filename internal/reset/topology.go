@@ -0,0 +1,284 @@
+package reset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// Topology is one uhubctl scan: every hub it can control, in the order
+// uhubctl listed them.
+type Topology struct {
+	Hubs []Hub `json:"hubs"`
+}
+
+// USBID is a vendor:product pair.
+type USBID struct {
+	Vendor  uint16 `json:"vendor"`
+	Product uint16 `json:"product"`
+}
+
+func (id USBID) String() string {
+	return fmt.Sprintf("%04x:%04x", id.Vendor, id.Product)
+}
+
+// MarshalText renders id as vvvv:pppp, the way lsusb and uhubctl print it.
+func (id USBID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText parses vvvv:pppp.
+func (id *USBID) UnmarshalText(b []byte) error {
+	v, p, err := device.ParseID(string(b))
+	if err != nil {
+		return err
+	}
+	*id = USBID{Vendor: v, Product: p}
+	return nil
+}
+
+// PowerSwitching is how a hub switches port power, as uhubctl reports it.
+type PowerSwitching string
+
+const (
+	// PowerPerPort hubs switch each port individually.
+	PowerPerPort PowerSwitching = "ppps"
+	// PowerGanged hubs switch all ports together.
+	PowerGanged PowerSwitching = "ganged"
+	// PowerNone hubs can't switch port power at all.
+	PowerNone PowerSwitching = "nops"
+)
+
+// Hub is one hub uhubctl can see.
+type Hub struct {
+	// Location is uhubctl's -l argument for this hub, e.g. "2-1.4".
+	Location string `json:"location"`
+	ID       USBID  `json:"id"`
+	// Description is the hub's manufacturer and product strings, if any.
+	Description string `json:"description,omitempty"`
+	// USBVersion is the hub's bcdUSB, e.g. "3.10".
+	USBVersion     string         `json:"usb_version"`
+	NumPorts       int            `json:"num_ports"`
+	PowerSwitching PowerSwitching `json:"power_switching"`
+	Ports          []Port         `json:"ports"`
+}
+
+// USB3 reports whether this is a SuperSpeed hub (or the SuperSpeed half of
+// a USB3 hub, which enumerates as two).
+func (h Hub) USB3() bool {
+	major, _, _ := strings.Cut(h.USBVersion, ".")
+	n, _ := strconv.Atoi(major)
+	return n >= 3
+}
+
+// Port returns the hub's port with the given number.
+func (h Hub) Port(n int) (Port, bool) {
+	for _, p := range h.Ports {
+		if p.Number == n {
+			return p, true
+		}
+	}
+	return Port{}, false
+}
+
+// Port is one downstream port and whatever is plugged into it.
+type Port struct {
+	Number int `json:"number"`
+	// Status is the raw wPortStatus word. Its bit layout differs between
+	// USB2 and USB3 hubs; the fields below are decoded from it.
+	Status      PortStatus `json:"status"`
+	Power       bool       `json:"power"`
+	Enable      bool       `json:"enable"`
+	Connect     bool       `json:"connect"`
+	Suspend     bool       `json:"suspend"`
+	Overcurrent bool       `json:"overcurrent"`
+	// Speed is the link speed uhubctl reported: "lowspeed" or "highspeed"
+	// on USB2 (full speed prints nothing), e.g. "5gbps" on USB3.
+	Speed string `json:"speed,omitempty"`
+	// LinkState is the USB3 link state, e.g. "U0" or "Rx.Detect".
+	LinkState string `json:"link_state,omitempty"`
+	// Device is what's attached, if anything.
+	Device *Device `json:"device,omitempty"`
+}
+
+// PortStatus is a raw wPortStatus word, shown in hex as uhubctl prints it.
+type PortStatus uint16
+
+// MarshalText renders s as four hex digits.
+func (s PortStatus) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%04x", uint16(s)), nil
+}
+
+// UnmarshalText parses four hex digits.
+func (s *PortStatus) UnmarshalText(b []byte) error {
+	v, err := strconv.ParseUint(string(b), 16, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port status %q: %w", b, err)
+	}
+	*s = PortStatus(v)
+	return nil
+}
+
+// Device is the descriptor uhubctl prints for an attached device.
+type Device struct {
+	ID USBID `json:"id"`
+	// Description is the manufacturer, product and serial strings, space
+	// separated. uhubctl doesn't delimit them, so they can't be told apart.
+	Description string `json:"description,omitempty"`
+}
+
+// Port status bits, from the USB 2.0 spec (table 11-21) and USB 3.x spec
+// (table 10-13).
+const (
+	portConnection  = 0x0001
+	portEnable      = 0x0002
+	portSuspend     = 0x0004 // USB2 only
+	portOvercurrent = 0x0008
+	portPowerUSB2   = 0x0100
+	portPowerUSB3   = 0x0200
+	portLinkState   = 0x01e0 // USB3 only
+	linkStateU3     = 0x0060
+)
+
+var (
+	hubHeaderRe = regexp.MustCompile(`^Current status for hub (\S+) \[([0-9a-f]{4}):([0-9a-f]{4})(?: (.*?))?, USB (\d+\.\d+), (\d+) ports?, (\w+)\]\s*$`)
+	portLineRe  = regexp.MustCompile(`^\s+Port\s+(\d+):\s+([0-9a-f]{4})(.*)$`)
+	deviceRe    = regexp.MustCompile(`^([0-9a-f]{4}):([0-9a-f]{4})(?: (.*))?$`)
+)
+
+// ParseTopology parses the output of uhubctl run with no arguments. Lines it
+// doesn't recognise (warnings, uhubctl's own notes) are skipped; a port line
+// before any hub header is an error.
+func ParseTopology(out []byte) (Topology, error) {
+	var t Topology
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := sc.Text()
+
+		if m := hubHeaderRe.FindStringSubmatch(line); m != nil {
+			ports, _ := strconv.Atoi(m[6])
+			t.Hubs = append(t.Hubs, Hub{
+				Location:       m[1],
+				ID:             parseID(m[2], m[3]),
+				Description:    m[4],
+				USBVersion:     m[5],
+				NumPorts:       ports,
+				PowerSwitching: PowerSwitching(m[7]),
+			})
+			continue
+		}
+
+		if m := portLineRe.FindStringSubmatch(line); m != nil {
+			if len(t.Hubs) == 0 {
+				return Topology{}, fmt.Errorf("line %d: port status before any hub", lineNo)
+			}
+			hub := &t.Hubs[len(t.Hubs)-1]
+			p, err := parsePort(m[1], m[2], m[3], hub.USB3())
+			if err != nil {
+				return Topology{}, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			hub.Ports = append(hub.Ports, p)
+		}
+	}
+	return t, sc.Err()
+}
+
+func parsePort(num, status, rest string, usb3 bool) (Port, error) {
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return Port{}, err
+	}
+	bits, err := strconv.ParseUint(status, 16, 16)
+	if err != nil {
+		return Port{}, err
+	}
+	s := uint16(bits)
+
+	p := Port{
+		Number:      n,
+		Status:      PortStatus(s),
+		Enable:      s&portEnable != 0,
+		Connect:     s&portConnection != 0,
+		Overcurrent: s&portOvercurrent != 0,
+	}
+	if usb3 {
+		p.Power = s&portPowerUSB3 != 0
+		p.Suspend = s&portLinkState == linkStateU3
+	} else {
+		p.Power = s&portPowerUSB2 != 0
+		p.Suspend = s&portSuspend != 0
+	}
+
+	flags, desc, hasDevice := strings.Cut(rest, " [")
+	if hasDevice {
+		desc = strings.TrimSuffix(strings.TrimSpace(desc), "]")
+		m := deviceRe.FindStringSubmatch(desc)
+		if m == nil {
+			return Port{}, fmt.Errorf("port %d: unrecognised device descriptor %q", n, desc)
+		}
+		p.Device = &Device{ID: parseID(m[1], m[2]), Description: m[3]}
+	}
+
+	for _, f := range strings.Fields(flags) {
+		switch {
+		case f == "lowspeed" || f == "highspeed" || strings.HasSuffix(f, "gbps"):
+			p.Speed = f
+		case usb3 && slices.Contains(linkStates, f):
+			p.LinkState = f
+		}
+	}
+	return p, nil
+}
+
+// linkStates are the USB3 link state names uhubctl prints.
+var linkStates = []string{
+	"U0", "U1", "U2", "U3", "SS.Disabled", "Rx.Detect", "SS.Inactive",
+	"Polling", "Recovery", "HotReset", "Compliance", "Loopback",
+}
+
+func parseID(vendor, product string) USBID {
+	v, _ := strconv.ParseUint(vendor, 16, 16)
+	p, _ := strconv.ParseUint(product, 16, 16)
+	return USBID{Vendor: uint16(v), Product: uint16(p)}
+}
+
+// ScanTopology runs uhubctl once and parses what it reports.
+func ScanTopology(uhubctlPath string) (Topology, error) {
+	// uhubctl may exit non-zero even after printing a full report (e.g. when
+	// some hub in the tree can't be opened), so only a silent failure counts.
+	out, err := exec.Command(uhubctlPath).Output()
+	if err != nil && len(out) == 0 {
+		return Topology{}, fmt.Errorf("uhubctl failed: %w", err)
+	}
+	return ParseTopology(out)
+}
+
+// FindDevice returns the location of the first port whose attached device
+// satisfies match.
+func (t Topology) FindDevice(match func(Device) bool) (Location, bool) {
+	for _, h := range t.Hubs {
+		for _, p := range h.Ports {
+			if p.Device != nil && match(*p.Device) {
+				return Location{Hub: h.Location, Port: strconv.Itoa(p.Number)}, true
+			}
+		}
+	}
+	return Location{}, false
+}
+
+// Hub returns the hub at location.
+func (t Topology) Hub(location string) (Hub, bool) {
+	for _, h := range t.Hubs {
+		if h.Location == location {
+			return h, true
+		}
+	}
+	return Hub{}, false
+}
@@ -0,0 +1,229 @@
+package reset
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/uhubctl/*.golden.json from the parser's output")
+
+// TestParseTopologyGolden parses each captured uhubctl report in
+// testdata/uhubctl and compares the tree against its .golden.json. Run with
+// -update after a deliberate parser change, and review the diff.
+func TestParseTopologyGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "uhubctl", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no uhubctl captures in testdata/uhubctl")
+	}
+
+	for _, in := range inputs {
+		name := strings.TrimSuffix(filepath.Base(in), ".txt")
+		t.Run(name, func(t *testing.T) {
+			out, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			topo, err := ParseTopology(out)
+			if err != nil {
+				t.Fatalf("ParseTopology: %v", err)
+			}
+
+			got, err := json.MarshalIndent(topo, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(in, ".txt") + ".golden.json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("parsed tree differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestFindCamLinkInCaptures(t *testing.T) {
+	tests := []struct {
+		capture string
+		want    Location
+	}{
+		{"via-vl817-dock", Location{Hub: "4-1", Port: "2"}},
+		{"rpi4", Location{Hub: "2", Port: "1"}},
+		{"genesys-gl3523-ganged", Location{Hub: "2-3", Port: "3"}},
+		{"realtek-rts5411-nops", Location{Hub: "2-2", Port: "1"}},
+		{"macos-caldigit-ts3", Location{Hub: "20-3.3", Port: "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.capture, func(t *testing.T) {
+			out, err := os.ReadFile(filepath.Join("testdata", "uhubctl", tt.capture+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			topo, err := ParseTopology(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loc, err := Locate(topo, device.CamLink)
+			if err != nil || loc != tt.want {
+				t.Errorf("Locate = %+v, %v; want %+v", loc, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePortStatus(t *testing.T) {
+	tests := []struct {
+		line string
+		usb3 bool
+		want Port
+	}{
+		{
+			line: "  Port 2: 0203 power 5gbps U0 enable connect [0fd9:007b Elgato Cam Link 4K 000513A1B2C3]",
+			usb3: true,
+			want: Port{Number: 2, Status: 0x0203, Power: true, Enable: true, Connect: true, Speed: "5gbps", LinkState: "U0",
+				Device: &Device{ID: USBID{0x0fd9, 0x007b}, Description: "Elgato Cam Link 4K 000513A1B2C3"}},
+		},
+		{
+			line: "  Port 3: 0263 power 5gbps U3 enable connect [0bda:8153]",
+			usb3: true,
+			want: Port{Number: 3, Status: 0x0263, Power: true, Enable: true, Connect: true, Suspend: true, Speed: "5gbps", LinkState: "U3",
+				Device: &Device{ID: USBID{0x0bda, 0x8153}}},
+		},
+		{
+			line: "  Port 3: 0080 off",
+			usb3: true,
+			want: Port{Number: 3, Status: 0x0080},
+		},
+		{
+			line: "  Port 4: 0507 power highspeed suspend enable connect [2109:8817 VIA Labs, Inc. USB Billboard Device]",
+			want: Port{Number: 4, Status: 0x0507, Power: true, Enable: true, Connect: true, Suspend: true, Speed: "highspeed",
+				Device: &Device{ID: USBID{0x2109, 0x8817}, Description: "VIA Labs, Inc. USB Billboard Device"}},
+		},
+		{
+			line: "  Port 2: 0108 power oc",
+			want: Port{Number: 2, Status: 0x0108, Power: true, Overcurrent: true},
+		},
+	}
+	for _, tt := range tests {
+		m := portLineRe.FindStringSubmatch(tt.line)
+		if m == nil {
+			t.Errorf("portLineRe did not match %q", tt.line)
+			continue
+		}
+		got, err := parsePort(m[1], m[2], m[3], tt.usb3)
+		if err != nil {
+			t.Errorf("parsePort(%q): %v", tt.line, err)
+			continue
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(tt.want)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("parsePort(%q)\n got %s\nwant %s", tt.line, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestParseTopologyRejectsOrphanPort(t *testing.T) {
+	if _, err := ParseTopology([]byte("  Port 1: 0100 power\n")); err == nil {
+		t.Error("port line with no hub header should fail")
+	}
+}
+
+func TestLocateBySerial(t *testing.T) {
+	// Two Cam Links on one hub; only the serial tells them apart.
+	topo, err := ParseTopology([]byte(`Current status for hub 4-1 [2109:0813 VIA Labs, Inc. USB3.0 Hub, USB 3.10, 4 ports, ppps]
+  Port 1: 0203 power 5gbps U0 enable connect [0fd9:007b Elgato Cam Link 4K 0005AAAA0001]
+  Port 2: 0203 power 5gbps U0 enable connect [0fd9:007b Elgato Cam Link 4K 0005BBBB0002]
+  Port 3: 0203 power 5gbps U0 enable connect [0fd9:006d Elgato Game Capture HD60 S+ 0007CCCC0003]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id, serial, match string
+		wantPort          string
+	}{
+		{"0fd9:007b", "", "", "1"},
+		{"0fd9:007b", "0005BBBB0002", "", "2"},
+		{"0fd9:006d", "", `HD60 S\+`, "3"},
+		{"0fd9:007b", "0007CCCC0003", "", ""},
+		{"0fd9:007b", "", "HD60", ""},
+	}
+	for _, tt := range tests {
+		sel, err := device.New(tt.id, tt.serial, tt.match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loc, err := Locate(topo, sel)
+		if tt.wantPort == "" {
+			if err == nil {
+				t.Errorf("Locate(%s) = %+v, want not found", sel, loc)
+			}
+			continue
+		}
+		if err != nil || loc.Port != tt.wantPort {
+			t.Errorf("Locate(%s) = %+v, %v; want port %s", sel, loc, err, tt.wantPort)
+		}
+	}
+}
+
+func TestFindCompanionBySysfsPeer(t *testing.T) {
+	env := Env{SysfsRoot: t.TempDir()}
+	// The USB3 half's port 2 is peered with the USB2 half's port 4, which
+	// topology matching would never guess.
+	usb3 := filepath.Join(env.SysfsRoot, "bus", "usb", "devices", "4-1", "4-1:1.0", "4-1-port2")
+	usb2 := filepath.Join(env.SysfsRoot, "bus", "usb", "devices", "3-1", "3-1:1.0", "3-1-port4")
+	root := filepath.Join(env.SysfsRoot, "bus", "usb", "devices", "usb2", "2-0:1.0", "usb2-port1")
+	rootPeer := filepath.Join(env.SysfsRoot, "bus", "usb", "devices", "usb1", "1-0:1.0", "usb1-port3")
+	for _, dir := range []string{usb3, usb2, root, rootPeer} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for from, to := range map[string]string{usb3: usb2, root: rootPeer} {
+		if err := os.Symlink(to, filepath.Join(from, "peer")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, ok := FindCompanion(env, Location{Hub: "4-1", Port: "2"}); !ok || got != (Location{Hub: "3-1", Port: "4"}) {
+		t.Errorf("FindCompanion(4-1 port 2) = %+v, %v; want 3-1 port 4", got, ok)
+	}
+	if got, ok := FindCompanion(env, Location{Hub: "2", Port: "1"}); !ok || got != (Location{Hub: "1", Port: "3"}) {
+		t.Errorf("FindCompanion(root 2 port 1) = %+v, %v; want 1 port 3", got, ok)
+	}
+}
+
+func TestFindCompanionWithoutPeer(t *testing.T) {
+	// The port is in sysfs but has no peer link, so there's no companion,
+	// however likely a twin hub in the uhubctl scan might look.
+	env := Env{SysfsRoot: t.TempDir()}
+	if err := os.MkdirAll(portDir(env.SysfsRoot, Location{Hub: "4-1", Port: "2"}), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := FindCompanion(env, Location{Hub: "4-1", Port: "2"}); ok {
+		t.Errorf("FindCompanion = %+v, want none", got)
+	}
+}
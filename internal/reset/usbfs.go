@@ -0,0 +1,88 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// KindUSBReset resets the device through usbfs: the USBDEVFS_RESET ioctl
+// on /dev/bus/usb/BBB/DDD, which has the host controller drive a port reset
+// and the kernel re-enumerate the device. It needs no hub power switching,
+// so it's the one to try when there's no PPPS hub. Linux only.
+const KindUSBReset = "usb-reset"
+
+// usbfsRoot is where usbfs device nodes live.
+const usbfsRoot = "/dev/bus/usb"
+
+// DeviceResetter issues a USB port reset on a usbfs device node.
+type DeviceResetter interface {
+	ResetDevice(node string) error
+}
+
+func init() {
+	strategies[KindUSBReset] = func(s Stage) (Strategy, error) {
+		if s.OffTime != 0 {
+			return nil, &ParamError{"off", fmt.Sprintf("%s has no off time, got %s", KindUSBReset, s.OffTime)}
+		}
+		return usbReset{}, nil
+	}
+}
+
+// deviceResetter returns env's DeviceResetter, defaulting to the ioctl.
+func (env Env) deviceResetter() DeviceResetter {
+	if env.DeviceResetter == nil {
+		return usbdevfsReset{}
+	}
+	return env.DeviceResetter
+}
+
+// usbfsNode is t's usbfs device node, e.g. /dev/bus/usb/004/007, from the
+// bus and device numbers sysfs gives it.
+func usbfsNode(env Env, t Target) (string, error) {
+	dir := usbDeviceDir(env, t)
+	bus, err := strconv.Atoi(readSysfsAttr(dir, "busnum"))
+	if err != nil {
+		return "", fmt.Errorf("no bus number for %s in sysfs", t.Location.deviceName())
+	}
+	dev, err := strconv.Atoi(readSysfsAttr(dir, "devnum"))
+	if err != nil {
+		return "", fmt.Errorf("no device number for %s in sysfs", t.Location.deviceName())
+	}
+	return filepath.Join(usbfsRoot, fmt.Sprintf("%03d", bus), fmt.Sprintf("%03d", dev)), nil
+}
+
+// usbReset resets the device's port through usbfs. Unlike a port cycle
+// there's nothing to give back afterwards: the device stays powered, and
+// the kernel re-enumerates it once the reset completes.
+type usbReset struct{}
+
+func (usbReset) Kind() string { return KindUSBReset }
+
+func (usbReset) Applicable(env Env, t Target) error {
+	if t.Location.Hub == "" {
+		return errors.New("device's hub port is unknown")
+	}
+	_, err := usbfsNode(env, t)
+	return err
+}
+
+// Execute resolves the usbfs node afresh, since the device number changes
+// every time the device enumerates, and resets it. The ioctl doesn't take
+// a context; it returns once the reset is done.
+func (usbReset) Execute(_ context.Context, env Env, t Target) error {
+	node, err := usbfsNode(env, t)
+	if err != nil {
+		return err
+	}
+	if err := env.deviceResetter().ResetDevice(node); err != nil {
+		return fmt.Errorf("resetting %s (%s): %w", t.Location.deviceName(), node, err)
+	}
+	return nil
+}
+
+func (usbReset) String() string {
+	return KindUSBReset + " via usbfs"
+}
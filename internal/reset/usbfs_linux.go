@@ -0,0 +1,24 @@
+package reset
+
+import (
+	"os"
+	"syscall"
+)
+
+// usbdevfsResetIoctl is USBDEVFS_RESET, _IO('U', 20).
+const usbdevfsResetIoctl = 0x5514
+
+// usbdevfsReset issues USBDEVFS_RESET on the node.
+type usbdevfsReset struct{}
+
+func (usbdevfsReset) ResetDevice(node string) error {
+	f, err := os.OpenFile(node, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), usbdevfsResetIoctl, 0); errno != 0 {
+		return os.NewSyscallError("ioctl USBDEVFS_RESET", errno)
+	}
+	return nil
+}
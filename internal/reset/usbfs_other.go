@@ -0,0 +1,12 @@
+//go:build !linux
+
+package reset
+
+import "errors"
+
+// usbdevfsReset is Linux only; usbfs doesn't exist elsewhere.
+type usbdevfsReset struct{}
+
+func (usbdevfsReset) ResetDevice(string) error {
+	return errors.ErrUnsupported
+}
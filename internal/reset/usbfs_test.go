@@ -0,0 +1,55 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeResetter records the nodes it's asked to reset.
+type fakeResetter struct {
+	nodes []string
+	err   error
+}
+
+func (f *fakeResetter) ResetDevice(node string) error {
+	f.nodes = append(f.nodes, node)
+	return f.err
+}
+
+func TestUSBReset(t *testing.T) {
+	root, tgt := fakeSysfs(t)
+	r := &fakeResetter{}
+	env := Env{SysfsRoot: root, DeviceResetter: r}
+	s, err := Stage{Kind: KindUSBReset}.Strategy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Applicable(env, tgt); err != nil {
+		t.Fatalf("Applicable = %v", err)
+	}
+	if err := s.Execute(context.Background(), env, tgt); err != nil {
+		t.Fatalf("Execute = %v", err)
+	}
+	if len(r.nodes) != 1 || r.nodes[0] != "/dev/bus/usb/004/007" {
+		t.Errorf("reset nodes = %q, want [/dev/bus/usb/004/007]", r.nodes)
+	}
+
+	r.err = errors.New("no such device")
+	if err := s.Execute(context.Background(), env, tgt); err == nil || !strings.Contains(err.Error(), "no such device") {
+		t.Errorf("Execute with a failing ioctl = %v, want its error", err)
+	}
+
+	gone := Target{Name: "gone", Location: Location{Hub: "4-1", Port: "3"}}
+	if err := s.Applicable(env, gone); err == nil {
+		t.Error("Applicable on a device not in sysfs = nil, want an error")
+	}
+}
+
+func TestUSBResetRejectsOff(t *testing.T) {
+	var pe *ParamError
+	if _, err := (Stage{Kind: KindUSBReset, OffTime: 1}).Strategy(); !errors.As(err, &pe) || pe.Param != "off" {
+		t.Errorf("Strategy() with off = %v, want an off ParamError", err)
+	}
+}
@@ -0,0 +1,107 @@
+package sleepwatch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// logind announces suspend/resume with PrepareForSleep(bool): true just
+// before the machine goes down, false right after it comes back. Only the
+// false edge is a wake.
+const (
+	logindSender    = "org.freedesktop.login1"
+	logindPath      = dbus.ObjectPath("/org/freedesktop/login1")
+	logindInterface = "org.freedesktop.login1.Manager"
+	logindMember    = "PrepareForSleep"
+)
+
+// reconnectDelay is how long to wait before redialling the bus after the
+// connection drops (dbus-daemon restart, broker upgrade). Overridden in tests.
+var reconnectDelay = 5 * time.Second
+
+// Watch returns a channel that receives a signal each time the machine wakes
+// from sleep, as reported by systemd-logind on the system bus. The watcher
+// reconnects if the bus goes away and stops when ctx is cancelled.
+func Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go watch(ctx, func() (*dbus.Conn, error) { return dbus.ConnectSystemBus() }, ch)
+	return ch
+}
+
+// watch keeps a PrepareForSleep subscription alive on whatever bus connect
+// dials, redialling after any disconnect until ctx is cancelled.
+func watch(ctx context.Context, connect func() (*dbus.Conn, error), ch chan<- struct{}) {
+	for {
+		err := subscribe(ctx, connect, ch)
+		if ctx.Err() != nil {
+			log.Println("sleepwatch: stopped")
+			return
+		}
+		log.Printf("sleepwatch: lost logind subscription (%v), reconnecting in %s", err, reconnectDelay)
+
+		select {
+		case <-ctx.Done():
+			log.Println("sleepwatch: stopped")
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// subscribe runs one connection's worth of PrepareForSleep delivery. It
+// returns when ctx is cancelled (nil) or the connection dies (the error).
+func subscribe(ctx context.Context, connect func() (*dbus.Conn, error), ch chan<- struct{}) error {
+	conn, err := connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchSender(logindSender),
+		dbus.WithMatchObjectPath(logindPath),
+		dbus.WithMatchInterface(logindInterface),
+		dbus.WithMatchMember(logindMember),
+	); err != nil {
+		return err
+	}
+
+	sigCh := make(chan *dbus.Signal, 4)
+	conn.Signal(sigCh)
+
+	log.Printf("sleepwatch: listening for logind %s", logindMember)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig, ok := <-sigCh:
+			// godbus closes registered signal channels when the connection
+			// is torn down, which is how a dropped bus shows up here.
+			if !ok {
+				return errors.New("bus connection closed")
+			}
+			if !isWake(sig) {
+				continue
+			}
+			log.Printf("sleepwatch: wake detected at %s", time.Now().Format(time.RFC3339))
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// isWake reports whether sig is PrepareForSleep(false).
+func isWake(sig *dbus.Signal) bool {
+	if sig.Name != logindInterface+"."+logindMember || len(sig.Body) != 1 {
+		return false
+	}
+	sleeping, ok := sig.Body[0].(bool)
+	return ok && !sleeping
+}
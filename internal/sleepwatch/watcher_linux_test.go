@@ -0,0 +1,181 @@
+package sleepwatch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// busConfig is a permissive private bus: anyone may own any name, so the test
+// can stand in for logind by claiming org.freedesktop.login1.
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus runs a private dbus-daemon listening on socket and returns a func
+// that kills it. Skips the test if dbus-daemon isn't installed.
+func startBus(t *testing.T, socket string) (stop func()) {
+	t.Helper()
+	bin, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	conf := filepath.Join(filepath.Dir(socket), "bus.conf")
+	if err := os.WriteFile(conf, []byte(fmt.Sprintf(busConfig, socket)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bin, "--nofork", "--print-address", "--config-file="+conf)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting dbus-daemon: %v", err)
+	}
+	// The daemon prints its address once it's accepting connections.
+	if !bufio.NewScanner(stdout).Scan() {
+		cmd.Process.Kill()
+		t.Fatal("dbus-daemon exited before printing its address")
+	}
+
+	stopped := false
+	stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.Remove(socket)
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// fakeLogind claims logind's bus name on addr and returns a func that emits
+// PrepareForSleep(sleeping).
+func fakeLogind(t *testing.T, addr string) func(sleeping bool) {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatalf("fake logind connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reply, err := conn.RequestName(logindSender, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("fake logind RequestName: reply=%v err=%v", reply, err)
+	}
+	return func(sleeping bool) {
+		if err := conn.Emit(logindPath, logindInterface+"."+logindMember, sleeping); err != nil {
+			t.Fatalf("emit PrepareForSleep(%v): %v", sleeping, err)
+		}
+	}
+}
+
+// awaitWake emits PrepareForSleep(false) until the watcher delivers a wake, so
+// the test doesn't race the watcher's AddMatch after (re)connecting.
+func awaitWake(t *testing.T, emit func(bool), ch <-chan struct{}) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		emit(false)
+		select {
+		case <-ch:
+			return
+		case <-tick.C:
+		case <-deadline:
+			t.Fatal("no wake delivered")
+		}
+	}
+}
+
+func TestWatchDeliversWakeOnly(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "bus")
+	startBus(t, socket)
+	addr := "unix:path=" + socket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan struct{}, 1)
+	go watch(ctx, func() (*dbus.Conn, error) { return dbus.Connect(addr) }, ch)
+
+	emit := fakeLogind(t, addr)
+	awaitWake(t, emit, ch)
+
+	// Going to sleep is not a wake.
+	emit(true)
+	select {
+	case <-ch:
+		t.Fatal("PrepareForSleep(true) delivered a wake")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchReconnectsAfterBusRestart(t *testing.T) {
+	old := reconnectDelay
+	reconnectDelay = 50 * time.Millisecond
+	t.Cleanup(func() { reconnectDelay = old })
+
+	socket := filepath.Join(t.TempDir(), "bus")
+	stop := startBus(t, socket)
+	addr := "unix:path=" + socket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan struct{}, 1)
+	go watch(ctx, func() (*dbus.Conn, error) { return dbus.Connect(addr) }, ch)
+
+	awaitWake(t, fakeLogind(t, addr), ch)
+
+	// Kill the bus out from under the watcher and bring a new one up at the
+	// same address.
+	stop()
+	startBus(t, socket)
+
+	awaitWake(t, fakeLogind(t, addr), ch)
+}
+
+func TestIsWake(t *testing.T) {
+	name := logindInterface + "." + logindMember
+	tests := []struct {
+		name string
+		sig  *dbus.Signal
+		want bool
+	}{
+		{"resume", &dbus.Signal{Name: name, Body: []any{false}}, true},
+		{"suspend", &dbus.Signal{Name: name, Body: []any{true}}, false},
+		{"other member", &dbus.Signal{Name: logindInterface + ".PrepareForShutdown", Body: []any{false}}, false},
+		{"malformed body", &dbus.Signal{Name: name, Body: []any{"false"}}, false},
+		{"empty body", &dbus.Signal{Name: name}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isWake(tt.sig); got != tt.want {
+				t.Errorf("isWake = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
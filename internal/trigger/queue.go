@@ -0,0 +1,140 @@
+// Package trigger serializes the daemon's check/reset cycles. Events that
+// arrive while a cycle is running aren't dropped: they're coalesced into a
+// single follow-up batch that runs once the current cycle finishes.
+package trigger
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock is the part of package time the queue needs, so tests can drive
+// settle delays by hand.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Batch is one or more triggers merged into a single check.
+type Batch struct {
+	// Names are the triggers that were merged, in arrival order, each once.
+	Names []string
+	// Due is when the batch may run: the latest of each trigger's arrival
+	// plus its settle delay.
+	Due time.Time
+}
+
+// String joins the trigger names, e.g. "wake+usb-arrival".
+func (b Batch) String() string {
+	return strings.Join(b.Names, "+")
+}
+
+// Queue runs batches one at a time on a single worker. At most one batch is
+// pending at any moment; every trigger submitted before it starts is merged
+// into it.
+type Queue struct {
+	clock Clock
+	run   func(context.Context, Batch)
+
+	mu      sync.Mutex
+	pending *Batch
+	kick    chan struct{}
+}
+
+// New returns a queue that calls run for each batch, passing along the
+// context given to Run. A nil clock means the real one.
+func New(clock Clock, run func(context.Context, Batch)) *Queue {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Queue{clock: clock, run: run, kick: make(chan struct{}, 1)}
+}
+
+// Submit records a trigger that should be checked once delay has passed. If
+// a batch is already pending, the trigger joins it and pushes its due time
+// out if need be; it never starts a second one.
+func (q *Queue) Submit(name string, delay time.Duration) {
+	due := q.clock.Now().Add(delay)
+
+	q.mu.Lock()
+	if q.pending == nil {
+		q.pending = &Batch{Names: []string{name}, Due: due}
+	} else {
+		if !slices.Contains(q.pending.Names, name) {
+			q.pending.Names = append(q.pending.Names, name)
+		}
+		if due.After(q.pending.Due) {
+			q.pending.Due = due
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns a copy of the batch waiting to run, if any.
+func (q *Queue) Pending() (Batch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		return Batch{}, false
+	}
+	b := *q.pending
+	b.Names = slices.Clone(b.Names)
+	return b, true
+}
+
+// Run is the worker loop. It returns when ctx is cancelled, once the batch in
+// progress (if any) has returned, abandoning any pending batch.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		b, ok := q.next(ctx)
+		if !ok {
+			return
+		}
+		q.run(ctx, b)
+	}
+}
+
+// next waits until the pending batch is due and takes it.
+func (q *Queue) next(ctx context.Context) (Batch, bool) {
+	for {
+		if ctx.Err() != nil {
+			return Batch{}, false
+		}
+		q.mu.Lock()
+		var wait time.Duration
+		var timer <-chan time.Time
+		if q.pending != nil {
+			wait = q.pending.Due.Sub(q.clock.Now())
+			if wait <= 0 {
+				b := *q.pending
+				q.pending = nil
+				q.mu.Unlock()
+				return b, true
+			}
+			timer = q.clock.After(wait)
+		}
+		q.mu.Unlock()
+
+		// A Submit while we wait may have pushed the due time out, so
+		// either way around the loop re-reads it.
+		select {
+		case <-ctx.Done():
+			return Batch{}, false
+		case <-q.kick:
+		case <-timer:
+		}
+	}
+}
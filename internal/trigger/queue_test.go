@@ -0,0 +1,215 @@
+package trigger
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to. After channels fire once Advance passes
+// their deadline.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = kept
+}
+
+// harness runs a queue whose run function records each batch and then
+// blocks until released, standing in for a long check/reset cycle.
+type harness struct {
+	t       *testing.T
+	clock   *fakeClock
+	q       *Queue
+	started chan Batch
+	release chan struct{}
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:       t,
+		clock:   newFakeClock(),
+		started: make(chan Batch),
+		release: make(chan struct{}),
+	}
+	h.q = New(h.clock, func(_ context.Context, b Batch) {
+		h.started <- b
+		<-h.release
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.q.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return h
+}
+
+func (h *harness) expectRun(names ...string) {
+	h.t.Helper()
+	select {
+	case b := <-h.started:
+		if !slices.Equal(b.Names, names) {
+			h.t.Fatalf("ran %v, want %v", b.Names, names)
+		}
+	case <-time.After(2 * time.Second):
+		h.t.Fatalf("batch %v never ran", names)
+	}
+}
+
+func (h *harness) expectIdle() {
+	h.t.Helper()
+	select {
+	case b := <-h.started:
+		h.t.Fatalf("unexpected run of %v", b.Names)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// settle advances the clock in small steps so the worker gets a chance to
+// arm its timer between them, whatever order the goroutines are scheduled in.
+func (h *harness) settle(d time.Duration) {
+	const step = 100 * time.Millisecond
+	for elapsed := time.Duration(0); elapsed < d; elapsed += step {
+		time.Sleep(time.Millisecond)
+		h.clock.Advance(step)
+	}
+}
+
+func TestQueueWaitsForDelay(t *testing.T) {
+	h := newHarness(t)
+
+	h.q.Submit("wake", 5*time.Second)
+	h.settle(4 * time.Second)
+	h.expectIdle()
+
+	h.settle(time.Second)
+	h.expectRun("wake")
+	h.release <- struct{}{}
+}
+
+func TestQueueCoalescesDuringRun(t *testing.T) {
+	h := newHarness(t)
+
+	h.q.Submit("startup", 0)
+	h.expectRun("startup")
+
+	// While the first cycle is busy (say, sleeping in its retry loop) a wake,
+	// a USB arrival and a second wake arrive. None of them is dropped, and
+	// none of them starts a cycle of its own.
+	h.q.Submit("wake", 5*time.Second)
+	h.q.Submit("usb-arrival", 2*time.Second)
+	h.q.Submit("wake", 5*time.Second)
+	h.settle(10 * time.Second)
+	h.expectIdle()
+
+	b, ok := h.q.Pending()
+	if !ok || !slices.Equal(b.Names, []string{"wake", "usb-arrival"}) {
+		t.Fatalf("pending = %v, %v; want wake+usb-arrival", b.Names, ok)
+	}
+
+	h.release <- struct{}{}
+	h.expectRun("wake", "usb-arrival")
+	h.release <- struct{}{}
+
+	if _, ok := h.q.Pending(); ok {
+		t.Error("queue should be empty after the follow-up ran")
+	}
+	h.expectIdle()
+}
+
+func TestQueueMergeExtendsDue(t *testing.T) {
+	h := newHarness(t)
+
+	h.q.Submit("manual", 0)
+	h.expectRun("manual")
+
+	h.q.Submit("usb-arrival", 2*time.Second)
+	h.settle(time.Second)
+	h.q.Submit("wake", 5*time.Second)
+	h.release <- struct{}{}
+
+	// The USB arrival alone would be due now, but the wake that joined it
+	// still needs its full 5s to settle.
+	h.settle(2 * time.Second)
+	h.expectIdle()
+
+	h.settle(3 * time.Second)
+	h.expectRun("usb-arrival", "wake")
+	h.release <- struct{}{}
+}
+
+func TestBatchString(t *testing.T) {
+	b := Batch{Names: []string{"wake", "usb-arrival", "manual (SIGUSR1)"}}
+	if got, want := b.String(), "wake+usb-arrival+manual (SIGUSR1)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestQueueRunStopsOnCancel(t *testing.T) {
+	ran := make(chan Batch, 1)
+	q := New(newFakeClock(), func(_ context.Context, b Batch) { ran <- b })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	q.Submit("wake", 5*time.Second)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	select {
+	case b := <-ran:
+		t.Errorf("ran %v after cancel", b.Names)
+	default:
+	}
+}
@@ -0,0 +1,35 @@
+package usbwatch
+
+// EventType distinguishes a device appearing from one going away.
+type EventType int
+
+const (
+	// Arrival is a matching device appearing on the bus.
+	Arrival EventType = iota
+	// Departure is a matching device leaving it.
+	Departure
+)
+
+func (t EventType) String() string {
+	if t == Departure {
+		return "departure"
+	}
+	return "arrival"
+}
+
+// Event is one matching device arriving or departing.
+type Event struct {
+	Type EventType
+	// Path identifies the device where the platform offers one (the sysfs
+	// devpath on Linux); it's empty on macOS.
+	Path string
+}
+
+// send delivers e without blocking the watcher. Events are hints to go and
+// look, so if the consumer has fallen this far behind, dropping one is fine.
+func send(ch chan<- Event, e Event) {
+	select {
+	case ch <- e:
+	default:
+	}
+}
@@ -0,0 +1,146 @@
+package usbwatch
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// uevent is one kernel object event as broadcast on NETLINK_KOBJECT_UEVENT.
+// The kernel sends a header line ("add@/devices/...") followed by
+// NUL-separated KEY=VALUE pairs:
+//
+//	add@/devices/pci0000:00/0000:00:14.0/usb3/3-1/3-1.2\0ACTION=add\0
+//	DEVPATH=/devices/...\0SUBSYSTEM=usb\0DEVTYPE=usb_device\0
+//	PRODUCT=fd9/7b/10\0BUSNUM=003\0DEVNUM=006\0...
+type uevent struct {
+	Action  string
+	DevPath string
+	Env     map[string]string
+}
+
+// parseUevent decodes a raw kernel uevent datagram. It rejects udev's
+// re-broadcast format ("libudev\0" + binary header), which we never subscribe
+// to but would otherwise misparse.
+func parseUevent(b []byte) (uevent, error) {
+	fields := bytes.Split(bytes.TrimRight(b, "\x00"), []byte{0})
+	if len(fields) == 0 || len(fields[0]) == 0 {
+		return uevent{}, fmt.Errorf("empty uevent")
+	}
+
+	action, devpath, ok := strings.Cut(string(fields[0]), "@")
+	if !ok {
+		return uevent{}, fmt.Errorf("uevent header %q has no action@devpath", fields[0])
+	}
+
+	e := uevent{Action: action, DevPath: devpath, Env: make(map[string]string, len(fields)-1)}
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(string(f), "=")
+		if !ok {
+			continue
+		}
+		e.Env[k] = v
+	}
+
+	// The header and ACTION/DEVPATH should agree; trust the env when present
+	// since that's what udev itself keys on.
+	if a := e.Env["ACTION"]; a != "" {
+		e.Action = a
+	}
+	if p := e.Env["DEVPATH"]; p != "" {
+		e.DevPath = p
+	}
+	return e, nil
+}
+
+// isUSBDevice reports whether the event is for a whole USB device, as opposed
+// to one of its interfaces (which arrive as separate usb_interface events and
+// would otherwise count the same plug-in several times).
+func (e uevent) isUSBDevice() bool {
+	return e.Env["SUBSYSTEM"] == "usb" && e.Env["DEVTYPE"] == "usb_device"
+}
+
+// ids extracts the vendor and product IDs from the PRODUCT key, which the
+// kernel formats as "%x/%x/%x" (idVendor/idProduct/bcdDevice, no padding).
+func (e uevent) ids() (vendorID, productID uint16, ok bool) {
+	parts := strings.Split(e.Env["PRODUCT"], "/")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	vid, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	pid, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint16(vid), uint16(pid), true
+}
+
+// matches reports whether the event describes the selected kind of USB
+// device. Only the IDs are in the event itself; see selects for the rest.
+func (e uevent) matches(sel device.Selector) bool {
+	if !e.isUSBDevice() {
+		return false
+	}
+	vid, pid, ok := e.ids()
+	return ok && sel.MatchesID(vid, pid)
+}
+
+// presentDevices counts USB devices under sysfsRoot that sel picks out — the
+// Linux equivalent of draining the IOKit iterator at startup. Interfaces
+// ("3-1.2:1.0") have no idVendor file and are skipped naturally.
+func presentDevices(sysfsRoot string, sel device.Selector) int {
+	dirs, err := filepath.Glob(filepath.Join(sysfsRoot, "bus", "usb", "devices", "*"))
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, dir := range dirs {
+		if selects(dir, sel) {
+			count++
+		}
+	}
+	return count
+}
+
+// selects reports whether the USB device at sysfs directory dir is the one
+// sel picks out, checking its serial and strings as well as its IDs.
+func selects(dir string, sel device.Selector) bool {
+	vid, ok := readHexAttr(filepath.Join(dir, "idVendor"))
+	if !ok {
+		return false
+	}
+	pid, ok := readHexAttr(filepath.Join(dir, "idProduct"))
+	if !ok {
+		return false
+	}
+	desc := strings.TrimSpace(readAttr(dir, "manufacturer") + " " + readAttr(dir, "product"))
+	return sel.Matches(vid, pid, readAttr(dir, "serial"), desc)
+}
+
+func readHexAttr(path string) (uint16, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(v), true
+}
+
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
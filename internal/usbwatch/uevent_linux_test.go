@@ -0,0 +1,185 @@
+package usbwatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// Captured with `udevadm monitor --kernel --property` style netlink reads
+// while plugging a Cam Link 4K into a VIA Labs hub behind a dock.
+const (
+	camLinkAdd = "add@/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"ACTION=add\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"SUBSYSTEM=usb\x00" +
+		"MAJOR=189\x00MINOR=386\x00" +
+		"DEVNAME=bus/usb/004/003\x00" +
+		"DEVTYPE=usb_device\x00" +
+		"PRODUCT=fd9/7b/10\x00" +
+		"TYPE=239/2/1\x00" +
+		"BUSNUM=004\x00DEVNUM=003\x00" +
+		"SEQNUM=6012\x00"
+
+	camLinkInterfaceAdd = "add@/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2/4-1.2:1.0\x00" +
+		"ACTION=add\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2/4-1.2:1.0\x00" +
+		"SUBSYSTEM=usb\x00" +
+		"DEVTYPE=usb_interface\x00" +
+		"PRODUCT=fd9/7b/10\x00" +
+		"TYPE=239/2/1\x00" +
+		"INTERFACE=14/1/0\x00" +
+		"MODALIAS=usb:v0FD9p007Bd0010dcEFdsc02dp01ic0Eisc01ip00in00\x00" +
+		"SEQNUM=6013\x00"
+
+	camLinkRemove = "remove@/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"ACTION=remove\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"SUBSYSTEM=usb\x00" +
+		"DEVNAME=bus/usb/004/003\x00" +
+		"DEVTYPE=usb_device\x00" +
+		"PRODUCT=fd9/7b/10\x00" +
+		"SEQNUM=6040\x00"
+
+	keyboardAdd = "add@/devices/pci0000:00/0000:00:14.0/usb3/3-1/3-1.4\x00" +
+		"ACTION=add\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb3/3-1/3-1.4\x00" +
+		"SUBSYSTEM=usb\x00" +
+		"DEVTYPE=usb_device\x00" +
+		"PRODUCT=4d9/a291/101\x00" +
+		"SEQNUM=6100\x00"
+
+	camLinkBind = "bind@/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"ACTION=bind\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2\x00" +
+		"SUBSYSTEM=usb\x00" +
+		"DEVTYPE=usb_device\x00" +
+		"DRIVER=usb\x00" +
+		"PRODUCT=fd9/7b/10\x00" +
+		"SEQNUM=6020\x00"
+)
+
+func TestParseUeventMatchesCamLink(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		wantAction  string
+		wantMatch   bool
+		wantDevPath string
+	}{
+		{
+			name:        "cam link device add",
+			raw:         camLinkAdd,
+			wantAction:  "add",
+			wantMatch:   true,
+			wantDevPath: "/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2",
+		},
+		{
+			name:        "interface add for the same device is not a device match",
+			raw:         camLinkInterfaceAdd,
+			wantAction:  "add",
+			wantMatch:   false,
+			wantDevPath: "/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2/4-1.2:1.0",
+		},
+		{
+			name:        "cam link device remove",
+			raw:         camLinkRemove,
+			wantAction:  "remove",
+			wantMatch:   true,
+			wantDevPath: "/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2",
+		},
+		{
+			name:        "bind after add still identifies the device",
+			raw:         camLinkBind,
+			wantAction:  "bind",
+			wantMatch:   true,
+			wantDevPath: "/devices/pci0000:00/0000:00:14.0/usb4/4-1/4-1.2",
+		},
+		{
+			name:        "other vendor does not match",
+			raw:         keyboardAdd,
+			wantAction:  "add",
+			wantMatch:   false,
+			wantDevPath: "/devices/pci0000:00/0000:00:14.0/usb3/3-1/3-1.4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseUevent([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("parseUevent: %v", err)
+			}
+			if e.Action != tt.wantAction {
+				t.Errorf("action = %q, want %q", e.Action, tt.wantAction)
+			}
+			if e.DevPath != tt.wantDevPath {
+				t.Errorf("devpath = %q, want %q", e.DevPath, tt.wantDevPath)
+			}
+			if got := e.matches(device.CamLink); got != tt.wantMatch {
+				t.Errorf("matches = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestParseUeventRejectsUdevFormat(t *testing.T) {
+	raw := "libudev\x00\xfe\xed\xca\xfe\x28\x00\x00\x00"
+	if _, err := parseUevent([]byte(raw)); err == nil {
+		t.Fatal("expected error for udev-format message")
+	}
+	if _, err := parseUevent(nil); err == nil {
+		t.Fatal("expected error for empty message")
+	}
+}
+
+func TestPresentDevices(t *testing.T) {
+	root := t.TempDir()
+	devices := filepath.Join(root, "bus", "usb", "devices")
+
+	write := func(dev, attr, val string) {
+		t.Helper()
+		dir := filepath.Join(devices, dev)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, attr), []byte(val+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("usb4", "idVendor", "1d6b")
+	write("usb4", "idProduct", "0003")
+	write("4-1", "idVendor", "2109")
+	write("4-1", "idProduct", "0813")
+	write("4-1.2", "idVendor", "0fd9")
+	write("4-1.2", "idProduct", "007b")
+	write("4-1.2:1.0", "bInterfaceClass", "0e")
+	write("3-1.4", "idVendor", "0fd9")
+	write("3-1.4", "idProduct", "0066") // a different Elgato product
+
+	write("4-1.2", "serial", "000513A1B2C3")
+	write("4-1.2", "manufacturer", "Elgato")
+	write("4-1.2", "product", "Cam Link 4K")
+
+	if got := presentDevices(root, device.CamLink); got != 1 {
+		t.Errorf("presentDevices = %d, want 1", got)
+	}
+	if got := presentDevices(root, device.Selector{VendorID: 0x0fd9, ProductID: 0x1234}); got != 0 {
+		t.Errorf("presentDevices for absent product = %d, want 0", got)
+	}
+	if got := presentDevices(filepath.Join(root, "missing"), device.CamLink); got != 0 {
+		t.Errorf("presentDevices with no sysfs = %d, want 0", got)
+	}
+
+	bySerial, _ := device.New("0fd9:007b", "000513A1B2C3", "Elgato Cam Link")
+	if got := presentDevices(root, bySerial); got != 1 {
+		t.Errorf("presentDevices by serial and match = %d, want 1", got)
+	}
+	otherSerial, _ := device.New("0fd9:007b", "0005FFFFFFFF", "")
+	if got := presentDevices(root, otherSerial); got != 0 {
+		t.Errorf("presentDevices for another serial = %d, want 0", got)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"log"
 	"runtime"
+	"sync"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
+
+	"github.com/phinze/camlink-fix/internal/device"
 )
 
 // CoreFoundation types
@@ -68,7 +71,7 @@ var (
 	ioNotificationPortGetRunLoopSource func(notify ioNotificationPortRef) cfRunLoopSourceRef
 	ioNotificationPortDestroy         func(notify ioNotificationPortRef)
 	ioObjectRelease                   func(object ioObjectT) ioReturn
-	ioServiceAddMatchingNotification  func(notifyPort ioNotificationPortRef, notificationType uintptr, matching cfMutableDictRef, callback uintptr, refCon unsafe.Pointer, notification *ioIteratorT) ioReturn
+	ioServiceAddMatchingNotification  func(notifyPort ioNotificationPortRef, notificationType uintptr, matching cfMutableDictRef, callback uintptr, refCon uintptr, notification *ioIteratorT) ioReturn
 	ioServiceMatching                 func(name []byte) cfMutableDictRef
 )
 
@@ -82,6 +85,9 @@ var kCFTypeDictionaryValueCallBacks uintptr
 // byte slice and pass a pointer to it.
 var ioServiceMatchedStr = append([]byte("IOServiceMatched"), 0)
 
+// kIOTerminatedNotification, likewise: "IOServiceTerminate".
+var ioServiceTerminateStr = append([]byte("IOServiceTerminate"), 0)
+
 func init() {
 	cf, err := purego.Dlopen("/System/Library/Frameworks/CoreFoundation.framework/CoreFoundation", purego.RTLD_LAZY|purego.RTLD_GLOBAL)
 	if err != nil {
@@ -126,11 +132,38 @@ func init() {
 
 }
 
-// callbackCtx holds the state passed to the IOKit callback.
-var callbackCtx *watcherCtx
+// watchers holds the state for each running Watch, keyed by the refCon its
+// notifications were registered with. IOKit hands the refCon back to the
+// callback; it's a plain number rather than a pointer so no Go memory is
+// ever held on the C side.
+var (
+	watchersMu  sync.Mutex
+	watchers    = map[uintptr]*watcherCtx{}
+	nextWatcher uintptr
+)
 
 type watcherCtx struct {
-	ch chan<- struct{}
+	ch chan<- Event
+}
+
+func addWatcher(w *watcherCtx) uintptr {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	nextWatcher++
+	watchers[nextWatcher] = w
+	return nextWatcher
+}
+
+func removeWatcher(refCon uintptr) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	delete(watchers, refCon)
+}
+
+func lookupWatcher(refCon uintptr) *watcherCtx {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	return watchers[refCon]
 }
 
 // drainIterator must be called each time the notification fires (and on
@@ -148,18 +181,26 @@ func drainIterator(iterator ioIteratorT) int {
 	return count
 }
 
-func matchCallback(_ unsafe.Pointer, iterator ioIteratorT) {
+func matchCallback(refCon uintptr, iterator ioIteratorT) {
 	n := drainIterator(iterator)
-	if n > 0 && callbackCtx != nil {
+	if w := lookupWatcher(refCon); n > 0 && w != nil {
 		log.Printf("usbwatch: USB device arrived (%d matched)", n)
-		select {
-		case callbackCtx.ch <- struct{}{}:
-		default:
-		}
+		send(w.ch, Event{Type: Arrival})
+	}
+}
+
+func terminateCallback(refCon uintptr, iterator ioIteratorT) {
+	n := drainIterator(iterator)
+	if w := lookupWatcher(refCon); n > 0 && w != nil {
+		log.Printf("usbwatch: USB device departed (%d matched)", n)
+		send(w.ch, Event{Type: Departure})
 	}
 }
 
-var matchCallbackPtr = purego.NewCallback(matchCallback)
+var (
+	matchCallbackPtr     = purego.NewCallback(matchCallback)
+	terminateCallbackPtr = purego.NewCallback(terminateCallback)
+)
 
 func cfStr(s string) cfStringRef {
 	b := []byte(s)
@@ -170,13 +211,47 @@ func cfInt32(v int32) cfNumberRef {
 	return cfNumberCreate(kCFAllocatorDefault, kCFNumberSInt32Type, unsafe.Pointer(&v))
 }
 
-// Watch returns a channel that receives a signal each time a USB device
-// matching the given vendor and product IDs appears on the bus. Uses IOKit's
+// usbMatching builds a matching dictionary for IOUSBHostDevice with a
+// vendor/product filter, and a serial number filter if sel has one, or
+// returns 0 if IOKit won't give us one. IOKit can't match on a regexp, so
+// sel.Match isn't applied here.
+func usbMatching(sel device.Selector) cfMutableDictRef {
+	matching := ioServiceMatching(append([]byte("IOUSBHostDevice"), 0))
+	if matching == 0 {
+		return 0
+	}
+
+	vidKey := cfStr("idVendor")
+	pidKey := cfStr("idProduct")
+	vidVal := cfInt32(int32(sel.VendorID))
+	pidVal := cfInt32(int32(sel.ProductID))
+
+	cfDictionarySetValue(matching, unsafe.Pointer(vidKey), unsafe.Pointer(vidVal))
+	cfDictionarySetValue(matching, unsafe.Pointer(pidKey), unsafe.Pointer(pidVal))
+
+	cfRelease(cfTypeRef(vidKey))
+	cfRelease(cfTypeRef(pidKey))
+	cfRelease(cfTypeRef(vidVal))
+	cfRelease(cfTypeRef(pidVal))
+
+	if sel.Serial != "" {
+		serialKey := cfStr("USB Serial Number")
+		serialVal := cfStr(sel.Serial)
+		cfDictionarySetValue(matching, unsafe.Pointer(serialKey), unsafe.Pointer(serialVal))
+		cfRelease(cfTypeRef(serialKey))
+		cfRelease(cfTypeRef(serialVal))
+	}
+	return matching
+}
+
+// Watch returns a channel that receives an Event each time the USB device
+// sel picks out appears on or leaves the bus. Uses IOKit's
 // IOServiceAddMatchingNotification for zero-CPU-cost waiting.
-// The watcher stops when ctx is cancelled.
-func Watch(ctx context.Context, vendorID, productID int32) <-chan struct{} {
-	ch := make(chan struct{}, 1)
-	callbackCtx = &watcherCtx{ch: ch}
+// The watcher stops when ctx is cancelled. Each call gets its own
+// notifications, so several devices can be watched at once.
+func Watch(ctx context.Context, sel device.Selector) <-chan Event {
+	ch := make(chan Event, 4)
+	refCon := addWatcher(&watcherCtx{ch: ch})
 
 	go func() {
 		runtime.LockOSThread()
@@ -185,53 +260,51 @@ func Watch(ctx context.Context, vendorID, productID int32) <-chan struct{} {
 		notifyPort := ioNotificationPortCreate(kIOMasterPortDefault)
 		if notifyPort == 0 {
 			log.Println("usbwatch: failed to create IONotificationPort")
+			removeWatcher(refCon)
 			return
 		}
 
-		// Create matching dictionary for IOUSBHostDevice with vendor/product filter
-		matching := ioServiceMatching(append([]byte("IOUSBHostDevice"), 0))
-		if matching == 0 {
-			log.Println("usbwatch: IOServiceMatching returned nil")
-			ioNotificationPortDestroy(notifyPort)
-			return
-		}
-
-		// Add vendor and product ID to the matching dictionary
-		vidKey := cfStr("idVendor")
-		pidKey := cfStr("idProduct")
-		vidVal := cfInt32(vendorID)
-		pidVal := cfInt32(productID)
-
-		cfDictionarySetValue(cfMutableDictRef(matching), unsafe.Pointer(vidKey), unsafe.Pointer(vidVal))
-		cfDictionarySetValue(cfMutableDictRef(matching), unsafe.Pointer(pidKey), unsafe.Pointer(pidVal))
-
-		cfRelease(cfTypeRef(vidKey))
-		cfRelease(cfTypeRef(pidKey))
-		cfRelease(cfTypeRef(vidVal))
-		cfRelease(cfTypeRef(pidVal))
-
-		// Register for matching notifications
-		// NOTE: matching dictionary is consumed by this call — do not release it
-		var iterator ioIteratorT
-		kr := ioServiceAddMatchingNotification(
-			notifyPort,
-			uintptr(unsafe.Pointer(&ioServiceMatchedStr[0])),
-			cfMutableDictRef(matching),
-			matchCallbackPtr,
-			nil,
-			&iterator,
-		)
-		if kr != kIOReturnSuccess {
-			log.Printf("usbwatch: IOServiceAddMatchingNotification failed: 0x%08x", kr)
-			ioNotificationPortDestroy(notifyPort)
-			return
+		// Register for arrival and departure notifications. Each call
+		// consumes its matching dictionary, so each gets its own — do not
+		// release them.
+		var matched, terminated ioIteratorT
+		for _, n := range []struct {
+			kind     []byte
+			callback uintptr
+			iterator *ioIteratorT
+		}{
+			{ioServiceMatchedStr, matchCallbackPtr, &matched},
+			{ioServiceTerminateStr, terminateCallbackPtr, &terminated},
+		} {
+			matching := usbMatching(sel)
+			if matching == 0 {
+				log.Println("usbwatch: IOServiceMatching returned nil")
+				ioNotificationPortDestroy(notifyPort)
+				removeWatcher(refCon)
+				return
+			}
+			kr := ioServiceAddMatchingNotification(
+				notifyPort,
+				uintptr(unsafe.Pointer(&n.kind[0])),
+				matching,
+				n.callback,
+				refCon,
+				n.iterator,
+			)
+			if kr != kIOReturnSuccess {
+				log.Printf("usbwatch: IOServiceAddMatchingNotification failed: 0x%08x", kr)
+				ioNotificationPortDestroy(notifyPort)
+				removeWatcher(refCon)
+				return
+			}
 		}
 
-		// Drain the iterator to arm the notification (IOKit requirement)
-		n := drainIterator(iterator)
+		// Drain the iterators to arm the notifications (IOKit requirement)
+		n := drainIterator(matched)
 		if n > 0 {
 			log.Printf("usbwatch: %d device(s) already present at startup", n)
 		}
+		drainIterator(terminated)
 
 		// Wire notification port into the current thread's run loop
 		rl := cfRunLoopGetCurrent()
@@ -244,11 +317,11 @@ func Watch(ctx context.Context, vendorID, productID int32) <-chan struct{} {
 			cfRunLoopStop(rl)
 		}()
 
-		log.Printf("usbwatch: listening for USB device arrivals (vendor=0x%04x product=0x%04x)", vendorID, productID)
+		log.Printf("usbwatch: listening for USB device arrivals and departures (%s)", sel)
 		cfRunLoopRun()
 
 		ioNotificationPortDestroy(notifyPort)
-		callbackCtx = nil
+		removeWatcher(refCon)
 		log.Println("usbwatch: stopped")
 	}()
 
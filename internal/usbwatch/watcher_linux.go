@@ -0,0 +1,116 @@
+package usbwatch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/phinze/camlink-fix/internal/device"
+)
+
+// sysfsRoot is where presentDevices looks for already-attached devices.
+// Overridden in tests.
+var sysfsRoot = "/sys"
+
+// ueventGroupKernel is the netlink multicast group the kernel broadcasts raw
+// uevents on. Group 2 is udev's re-broadcast, which we don't want: it arrives
+// later and in a different wire format.
+const ueventGroupKernel = 1
+
+// Watch returns a channel that receives an Event each time the USB device
+// sel picks out appears on or leaves the bus. Departures are matched on IDs
+// alone, since the device's serial and strings are gone from sysfs by the
+// time the event arrives. Listens on a NETLINK_KOBJECT_UEVENT socket, so
+// waiting costs nothing until the kernel has something to say. The watcher
+// stops when ctx is cancelled.
+func Watch(ctx context.Context, sel device.Selector) <-chan Event {
+	ch := make(chan Event, 4)
+
+	go func() {
+		sock, err := openUeventSocket()
+		if err != nil {
+			log.Printf("usbwatch: could not open uevent socket: %v", err)
+			return
+		}
+
+		// Closing the socket is what unblocks the Read below on cancel.
+		go func() {
+			<-ctx.Done()
+			sock.Close()
+		}()
+
+		if n := presentDevices(sysfsRoot, sel); n > 0 {
+			log.Printf("usbwatch: %d device(s) already present at startup", n)
+		}
+
+		log.Printf("usbwatch: listening for USB device arrivals and departures (%s)", sel)
+
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := sock.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				// The kernel drops events when our receive buffer overflows
+				// (a dock full of devices enumerating at once). We lose
+				// those events but the socket is still good.
+				if errors.Is(err, syscall.ENOBUFS) {
+					log.Printf("usbwatch: uevent buffer overrun, some events were dropped")
+					continue
+				}
+				log.Printf("usbwatch: uevent read failed: %v", err)
+				return
+			}
+
+			e, err := parseUevent(buf[:n])
+			if err != nil || !e.matches(sel) {
+				continue
+			}
+
+			switch e.Action {
+			case "add":
+				// The kernel populates sysfs before announcing the device,
+				// so its serial and strings can be checked now.
+				if (sel.Serial != "" || sel.Match != nil) && !selects(filepath.Join(sysfsRoot, e.DevPath), sel) {
+					continue
+				}
+				log.Printf("usbwatch: USB device arrived (%s)", e.DevPath)
+				send(ch, Event{Type: Arrival, Path: e.DevPath})
+			case "remove":
+				log.Printf("usbwatch: USB device departed (%s)", e.DevPath)
+				send(ch, Event{Type: Departure, Path: e.DevPath})
+			}
+		}
+
+		log.Println("usbwatch: stopped")
+	}()
+
+	return ch
+}
+
+// openUeventSocket binds a netlink socket to the kernel uevent group. The fd
+// is non-blocking so os.NewFile hands it to the runtime poller, which makes
+// Close from another goroutine interrupt a pending Read.
+func openUeventSocket() (*os.File, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK,
+		syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+
+	// A docking event can emit hundreds of uevents in a burst; the default
+	// receive buffer overflows easily.
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20)
+
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventGroupKernel}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "uevent"), nil
+}